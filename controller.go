@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kubernetesinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+
+	bigipv1 "github.com/Nexinto/k8s-bigip-ipam/pkg/apis/bigip.nexinto.com/v1"
+)
+
+// How often a failed item is requeued before it is left to the next resync.
+const maxRetries = 15
+
+// The parts of the controller that controller-gen can't generate: the event handlers, named
+// queues and the filtered ConfigMap informer with its indexer. Pods are watched on demand, see
+// podWatcher.
+// Expects c.Initialize() to be called before.
+func (c *Controller) InitializeHooks() {
+
+	// named queues for the workqueue metrics; nothing uses the generated ones yet
+	c.ServiceQueue = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Service")
+	c.NamespaceQueue = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Namespace")
+	c.IngressQueue = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Ingress")
+	c.IpAddressQueue = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "IpAddress")
+	c.VirtualServerPolicyQueue = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "VirtualServerPolicy")
+
+	// Deleted objects are gone from the caches, so the queue holds the objects instead of keys.
+	// The deletion handlers write to the API, so they must not run on a standby instance.
+	c.DeletionQueue = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Deletion")
+
+	c.KubernetesFactory.Core().V1().Services().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { enqueue(c.ServiceQueue, obj) },
+		UpdateFunc: func(old, new interface{}) { enqueue(c.ServiceQueue, new) },
+		DeleteFunc: c.enqueueDeletion,
+	})
+
+	c.KubernetesFactory.Core().V1().Namespaces().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { enqueue(c.NamespaceQueue, obj) },
+		UpdateFunc: func(old, new interface{}) { enqueue(c.NamespaceQueue, new) },
+	})
+
+	c.KubernetesFactory.Extensions().V1beta1().Ingresses().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { enqueue(c.IngressQueue, obj) },
+		UpdateFunc: func(old, new interface{}) { enqueue(c.IngressQueue, new) },
+		DeleteFunc: c.enqueueDeletion,
+	})
+
+	c.IpamFactory.Ipam().V1().IpAddresses().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) { enqueue(c.IpAddressQueue, new) },
+		DeleteFunc: c.enqueueDeletion,
+	})
+
+	c.BigipFactory.Bigip().V1().VirtualServerPolicies().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { enqueue(c.VirtualServerPolicyQueue, obj) },
+		UpdateFunc: func(old, new interface{}) { enqueue(c.VirtualServerPolicyQueue, new) },
+		DeleteFunc: c.enqueueDeletion,
+	})
+
+	// Only the generated ConfigMaps are watched, see ConfigMapListOptions.
+	c.ConfigMapFactory = kubernetesinformers.NewSharedInformerFactoryWithOptions(c.Kubernetes, time.Second*30, kubernetesinformers.WithTweakListOptions(c.ConfigMapListOptions))
+
+	ConfigMapInformer := c.ConfigMapFactory.Core().V1().ConfigMaps()
+	c.ConfigMapQueue = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "ConfigMap")
+	c.ConfigMapLister = ConfigMapInformer.Lister()
+	c.ConfigMapSynced = ConfigMapInformer.Informer().HasSynced
+
+	if err := ConfigMapInformer.Informer().AddIndexers(c.ConfigMapIndexers()); err != nil {
+		panic(err.Error())
+	}
+	c.ConfigMapIndexer = ConfigMapInformer.Informer().GetIndexer()
+
+	ConfigMapInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { enqueue(c.ConfigMapQueue, obj) },
+		UpdateFunc: func(old, new interface{}) { enqueue(c.ConfigMapQueue, new) },
+		DeleteFunc: c.enqueueDeletion,
+	})
+}
+
+// The caches of the informers set up by Initialize() and InitializeHooks(), by resource.
+func (c *Controller) cachesSynced() map[string]cache.InformerSynced {
+	return map[string]cache.InformerSynced{
+		"Service":             c.ServiceSynced,
+		"ConfigMap":           c.ConfigMapSynced,
+		"Namespace":           c.NamespaceSynced,
+		"Ingress":             c.IngressSynced,
+		"IpAddress":           c.IpAddressSynced,
+		"VirtualServerPolicy": c.VirtualServerPolicySynced,
+	}
+}
+
+// Wait until all caches are synced; returns false if stopCh is closed before.
+func (c *Controller) waitForCaches(stopCh <-chan struct{}) bool {
+	synced := []cache.InformerSynced{}
+	for _, hasSynced := range c.cachesSynced() {
+		synced = append(synced, hasSynced)
+	}
+	return cache.WaitForCacheSync(stopCh, synced...)
+}
+
+// Start the ConfigMap informer and the ConfigMap and deletion workers next to Run(); returns
+// when stopCh is closed.
+func (c *Controller) RunHooks(stopCh <-chan struct{}) {
+
+	defer runtime.HandleCrash()
+	defer c.ConfigMapQueue.ShutDown()
+	defer c.DeletionQueue.ShutDown()
+
+	go c.ConfigMapFactory.Start(stopCh)
+
+	if !c.waitForCaches(stopCh) {
+		runtime.HandleError(fmt.Errorf("Timed out waiting for caches to sync"))
+		return
+	}
+
+	go wait.Until(c.runConfigMapWorker, time.Second, stopCh)
+
+	go wait.Until(c.runDeletionWorker, time.Second, stopCh)
+
+	c.health.setRunning()
+	<-stopCh
+}
+
+func (c *Controller) runConfigMapWorker() {
+	for c.processNextConfigMap() {
+	}
+}
+
+func (c *Controller) processNextConfigMap() bool {
+	obj, shutdown := c.ConfigMapQueue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.ConfigMapQueue.Done(obj)
+
+	key, ok := obj.(string)
+	if !ok {
+		c.ConfigMapQueue.Forget(obj)
+		runtime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
+		return true
+	}
+
+	err := c.processConfigMap(key)
+	requeueOnError(c.ConfigMapQueue, key, &err)
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("error syncing '%s': %s", key, err.Error()))
+		return true
+	}
+
+	c.ConfigMapQueue.Forget(obj)
+	return true
+}
+
+func (c *Controller) processConfigMap(key string) error {
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return fmt.Errorf("could not parse name %s: %s", key, err.Error())
+	}
+
+	o, err := c.ConfigMapLister.ConfigMaps(namespace).Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return fmt.Errorf("tried to get %s, but it was not found", key)
+		} else {
+			return fmt.Errorf("error getting %s from cache: %s", key, err.Error())
+		}
+	}
+
+	return c.ConfigMapCreatedOrUpdated(o)
+}
+
+func (c *Controller) enqueueDeletion(obj interface{}) {
+	if o := deletedObject(obj); o != nil {
+		c.DeletionQueue.Add(o)
+	}
+}
+
+func (c *Controller) runDeletionWorker() {
+	for c.processNextDeletion() {
+	}
+}
+
+func (c *Controller) processNextDeletion() bool {
+	obj, shutdown := c.DeletionQueue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.DeletionQueue.Done(obj)
+
+	if err := c.processDeletion(obj); err != nil {
+		if c.DeletionQueue.NumRequeues(obj) < maxRetries {
+			c.DeletionQueue.AddRateLimited(obj)
+			runtime.HandleError(fmt.Errorf("failed to process deletion: %s, requeuing", err.Error()))
+			return true
+		}
+		runtime.HandleError(fmt.Errorf("failed to process deletion: %s, giving up", err.Error()))
+	}
+
+	c.DeletionQueue.Forget(obj)
+	return true
+}
+
+func (c *Controller) processDeletion(obj interface{}) error {
+	switch o := obj.(type) {
+	case *corev1.Service:
+		return c.ServiceDeleted(o)
+	case *corev1.ConfigMap:
+		return c.ConfigMapDeleted(o)
+	case *extensionsv1beta1.Ingress:
+		return c.IngressDeleted(o)
+	case *ipamv1.IpAddress:
+		return c.IpAddressDeleted(o)
+	case *bigipv1.VirtualServerPolicy:
+		return c.VirtualServerPolicyDeleted(o)
+	}
+	runtime.HandleError(fmt.Errorf("unexpected deleted object %#v", obj))
+	return nil
+}
+
+// The generated workers drop items that fail. The XCreatedOrUpdated handlers defer this to put
+// the item back into the queue instead, until it has failed maxRetries times.
+func requeueOnError(queue workqueue.RateLimitingInterface, obj interface{}, err *error) {
+	if *err == nil {
+		return
+	}
+
+	key, ok := obj.(string)
+	if !ok {
+		var kerr error
+		if key, kerr = cache.MetaNamespaceKeyFunc(obj); kerr != nil {
+			return
+		}
+	}
+
+	if queue.NumRequeues(key) < maxRetries {
+		queue.AddRateLimited(key)
+		return
+	}
+	log.Warnf("giving up on '%s' after %d retries: %s", key, maxRetries, (*err).Error())
+	queue.Forget(key)
+}
+
+func enqueue(queue workqueue.RateLimitingInterface, obj interface{}) {
+	if key, err := cache.MetaNamespaceKeyFunc(obj); err == nil {
+		queue.Add(key)
+	}
+}
+
+// Unwrap the object from a tombstone; returns nil if there is none.
+func deletedObject(obj interface{}) interface{} {
+	tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+	if !ok {
+		return obj
+	}
+	if tombstone.Obj == nil {
+		log.Errorf("couldn't get object from tombstone %+v", obj)
+	}
+	return tombstone.Obj
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Compare a generated ConfigMap with the wanted one. Only the parts managed by the
+// controller are compared; JSON values are compared after parsing, so formatting
+// changes are not reported. Returns a description of every difference.
+func configMapDiff(actual, wanted *corev1.ConfigMap) []string {
+	diff := []string{}
+
+	for _, k := range sortedKeys(wanted.Labels) {
+		if v, ok := actual.Labels[k]; !ok {
+			diff = append(diff, fmt.Sprintf("label %s: missing, want %q", k, wanted.Labels[k]))
+		} else if v != wanted.Labels[k] {
+			diff = append(diff, fmt.Sprintf("label %s: %q, want %q", k, v, wanted.Labels[k]))
+		}
+	}
+
+	if actual.Annotations[AnnVirtualServerIP] != wanted.Annotations[AnnVirtualServerIP] {
+		diff = append(diff, fmt.Sprintf("annotation %s: %q, want %q", AnnVirtualServerIP, actual.Annotations[AnnVirtualServerIP], wanted.Annotations[AnnVirtualServerIP]))
+	}
+
+	if !reflect.DeepEqual(actual.OwnerReferences, wanted.OwnerReferences) {
+		diff = append(diff, "owner references changed")
+	}
+
+	keys := map[string]string{}
+	for k := range actual.Data {
+		keys[k] = ""
+	}
+	for k := range wanted.Data {
+		keys[k] = ""
+	}
+
+	for _, k := range sortedKeys(keys) {
+		a, aok := actual.Data[k]
+		w, wok := wanted.Data[k]
+		switch {
+		case !wok:
+			diff = append(diff, fmt.Sprintf("data %s: unexpected key", k))
+		case !aok:
+			diff = append(diff, fmt.Sprintf("data %s: missing", k))
+		case a == w:
+		default:
+			var av, wv interface{}
+			if json.Unmarshal([]byte(a), &av) != nil || json.Unmarshal([]byte(w), &wv) != nil {
+				diff = append(diff, fmt.Sprintf("data %s: %q, want %q", k, a, w))
+			} else {
+				diff = append(diff, jsonDiff("data "+k, av, wv)...)
+			}
+		}
+	}
+
+	return diff
+}
+
+// Describe the differences between two parsed JSON values.
+func jsonDiff(path string, actual, wanted interface{}) []string {
+	if am, ok := actual.(map[string]interface{}); ok {
+		if wm, ok := wanted.(map[string]interface{}); ok {
+			keys := map[string]string{}
+			for k := range am {
+				keys[k] = ""
+			}
+			for k := range wm {
+				keys[k] = ""
+			}
+			diff := []string{}
+			for _, k := range sortedKeys(keys) {
+				diff = append(diff, jsonDiff(path+"."+k, am[k], wm[k])...)
+			}
+			return diff
+		}
+	}
+
+	if as, ok := actual.([]interface{}); ok {
+		if ws, ok := wanted.([]interface{}); ok && len(as) == len(ws) {
+			diff := []string{}
+			for i := range as {
+				diff = append(diff, jsonDiff(fmt.Sprintf("%s[%d]", path, i), as[i], ws[i])...)
+			}
+			return diff
+		}
+	}
+
+	if reflect.DeepEqual(actual, wanted) {
+		return nil
+	}
+
+	return []string{fmt.Sprintf("%s: %s, want %s", path, jsonString(actual), jsonString(wanted))}
+}
+
+func jsonString(v interface{}) string {
+	if v == nil {
+		return "<none>"
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
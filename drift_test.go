@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+// Test that the ConfigMap comparison ignores JSON formatting and reports changed values
+func TestConfigMapDiff(t *testing.T) {
+	a := assert.New(t)
+
+	wanted := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      map[string]string{"f5type": "virtual-server"},
+			Annotations: map[string]string{AnnVirtualServerIP: "10.1.1.1"},
+		},
+		Data: map[string]string{
+			"schema": "f5schemadb://bigip-virtual-server_v0.1.3.json",
+			"data":   `{"virtualServer":{"frontend":{"balance":"round-robin","virtualAddress":{"port":80}}}}`,
+		},
+	}
+
+	actual := wanted.DeepCopy()
+	actual.Data["data"] = `{ "virtualServer": { "frontend": { "virtualAddress": { "port": 80 }, "balance": "round-robin" } } }`
+	actual.Annotations[AnnVirtualServerIPStatus] = "10.1.1.1"
+	actual.Labels["other"] = "label"
+
+	a.Empty(configMapDiff(actual, wanted))
+
+	actual.Data["data"] = `{"virtualServer":{"frontend":{"balance":"least-connections-member","virtualAddress":{"port":80}}}}`
+	actual.Labels["f5type"] = "other"
+	actual.Data["schema"] = "f5schemadb://bigip-virtual-server_v0.1.7.json"
+
+	a.Equal([]string{
+		`label f5type: "other", want "virtual-server"`,
+		`data data.virtualServer.frontend.balance: "least-connections-member", want "round-robin"`,
+		`data schema: "f5schemadb://bigip-virtual-server_v0.1.7.json", want "f5schemadb://bigip-virtual-server_v0.1.3.json"`,
+	}, configMapDiff(actual, wanted))
+}
+
+// Test that manual changes to a ConfigMap are reverted, unless the ConfigMap is pinned
+func TestConfigMapDrift(t *testing.T) {
+	c := testEnvironment()
+	a := assert.New(t)
+
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "myservice",
+			Namespace:   "default",
+			Annotations: map[string]string{},
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{
+				{
+					Port:     80,
+					NodePort: 33978,
+				},
+				{
+					Port:     443,
+					NodePort: 32156,
+				},
+			},
+		},
+	}
+
+	_, err := c.Kubernetes.CoreV1().Services("default").Create(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	// change the balancing method in both ConfigMaps, pin one of them
+
+	for _, name := range []string{"bigip-myservice-80", "bigip-myservice-443"} {
+		cm, err := c.Kubernetes.CoreV1().ConfigMaps("default").Get(name, metav1.GetOptions{})
+		if !a.Nil(err) {
+			return
+		}
+
+		f5 := &F5VirtualServerConfig{}
+		if err := json.Unmarshal([]byte(cm.Data["data"]), f5); !a.Nil(err) {
+			return
+		}
+		f5.VirtualServer.Frontend.Balance = "least-connections-member"
+		d, _ := json.Marshal(f5)
+		cm.Data["data"] = string(d)
+
+		if name == "bigip-myservice-443" {
+			cm.Annotations[AnnNxPinned] = "true"
+		}
+
+		_, err = c.Kubernetes.CoreV1().ConfigMaps("default").Update(cm)
+		if !a.Nil(err) {
+			return
+		}
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	for name, balance := range map[string]string{
+		"bigip-myservice-80":  "round-robin",
+		"bigip-myservice-443": "least-connections-member",
+	} {
+		cm, err := c.Kubernetes.CoreV1().ConfigMaps("default").Get(name, metav1.GetOptions{})
+		if !a.Nil(err) {
+			return
+		}
+
+		f5 := &F5VirtualServerConfig{}
+		if err := json.Unmarshal([]byte(cm.Data["data"]), f5); !a.Nil(err) {
+			return
+		}
+		a.Equal(balance, f5.VirtualServer.Frontend.Balance, name)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Nexinto/k8s-lbutil"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+)
+
+const (
+	// Comma separated list of the IP families for the VIPs of a Service (IPv4, IPv6; the default is IPv4)
+	AnnNxReqVIPFamilies = "nexinto.com/req-vip-families"
+
+	// The IPv6 VIP assigned by IPAM, and the IPv6 VIP once its virtual servers are configured
+	AnnNxAssignedVIPv6 = "nexinto.com/assigned-vip-ipv6"
+	AnnNxVIPv6         = "nexinto.com/vip-ipv6"
+
+	// The family of the address requested with an IpAddress. IPAM does not read it; the IpAddresses
+	// for IPv6 VIPs are labelled with IPv6Labels, so that they are served by an IPAM controller with
+	// an IPv6 network.
+	AnnNxIPFamily = "nexinto.com/ip-family"
+
+	// Generated ConfigMaps for IPv6 VIPs are labelled with the family
+	LabelIPFamily = "nexinto.com/ip-family"
+)
+
+type ipFamily string
+
+const (
+	ipv4 ipFamily = "IPv4"
+	ipv6 ipFamily = "IPv6"
+)
+
+// Parse the IP families requested by a Service. The IPv4 address is requested by lbutil.EnsureVIP
+// for every Service, so a Service can ask for IPv4 or for IPv4 and IPv6.
+func parseFamilies(s string) ([]ipFamily, error) {
+	if strings.TrimSpace(s) == "" {
+		return []ipFamily{ipv4}, nil
+	}
+
+	wanted := map[ipFamily]bool{}
+	for _, f := range strings.Split(s, ",") {
+		switch strings.ToLower(strings.TrimSpace(f)) {
+		case "ipv4":
+			wanted[ipv4] = true
+		case "ipv6":
+			wanted[ipv6] = true
+		default:
+			return nil, fmt.Errorf("unknown IP family '%s' in annotation %s, expected IPv4 or IPv6", strings.TrimSpace(f), AnnNxReqVIPFamilies)
+		}
+	}
+
+	if !wanted[ipv4] {
+		return nil, fmt.Errorf("annotation %s must include IPv4, IPv6-only VIPs are not supported", AnnNxReqVIPFamilies)
+	}
+
+	if wanted[ipv6] {
+		return []ipFamily{ipv4, ipv6}, nil
+	}
+	return []ipFamily{ipv4}, nil
+}
+
+func (s *vsSettings) wantsFamily(family ipFamily) bool {
+	for _, f := range s.families {
+		if f == family {
+			return true
+		}
+	}
+	return false
+}
+
+// Does the Service ask for a VIP of the family of a generated ConfigMap?
+func wantsFamilyOf(service *corev1.Service, configMap *corev1.ConfigMap) bool {
+	families, err := parseFamilies(service.Annotations[AnnNxReqVIPFamilies])
+	if err != nil {
+		return false
+	}
+	for _, f := range families {
+		if f == configMapFamilyOf(configMap) {
+			return true
+		}
+	}
+	return false
+}
+
+// The VIP assigned to a Service for an IP family.
+func assignedVIP(service *corev1.Service, family ipFamily) string {
+	if family == ipv6 {
+		return service.Annotations[AnnNxAssignedVIPv6]
+	}
+	return service.Annotations[lbutil.AnnNxAssignedVIP]
+}
+
+// The VIPs assigned to a Service for the families.
+func assignedVIPs(service *corev1.Service, families []ipFamily) []string {
+	vips := []string{}
+	for _, family := range families {
+		vips = append(vips, assignedVIP(service, family))
+	}
+	return vips
+}
+
+// The annotation with the VIP of a family once its virtual servers are configured.
+func readyVIPAnnotation(family ipFamily) string {
+	if family == ipv6 {
+		return AnnNxVIPv6
+	}
+	return lbutil.AnnNxVIP
+}
+
+// Are the VIPs of all families reported as configured?
+func vipsReady(service *corev1.Service, families []ipFamily) bool {
+	for _, family := range families {
+		if service.Annotations[readyVIPAnnotation(family)] != assignedVIP(service, family) {
+			return false
+		}
+	}
+	return true
+}
+
+func ipv6AddressName(service *corev1.Service) string {
+	return service.Name + "-ipv6"
+}
+
+// Request the IPv6 address for a Service. Returns the address once IPAM has assigned it.
+// The IpAddress is owned by the Service and removed with it, unless VIPs are retained.
+func (c *Controller) ensureIPv6VIP(service *corev1.Service) (string, error) {
+	name := ipv6AddressName(service)
+
+	address, err := c.IpAddressLister.IpAddresses(service.Namespace).Get(name)
+	if errors.IsNotFound(err) {
+		address = &ipamv1.IpAddress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   service.Namespace,
+				Annotations: map[string]string{AnnNxIPFamily: string(ipv6)},
+				OwnerReferences: []metav1.OwnerReference{{
+					Kind:       "Service",
+					APIVersion: "v1",
+					Name:       service.Name,
+					UID:        service.GetUID(),
+				}},
+			},
+		}
+		c.setIPv6Labels(address)
+		c.setRetention(address, service)
+		_, err = c.IpamClient.IpamV1().IpAddresses(service.Namespace).Create(address)
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return "", err
+		}
+		log.Infof("requested ipv6 address '%s-%s' for service '%s-%s'", address.Namespace, address.Name, service.Namespace, service.Name)
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	if !belongsTo(address, service) {
+		return "", fmt.Errorf("IpAddress '%s' exists, but does not belong to this Service", name)
+	}
+
+	newaddress := address.DeepCopy()
+	c.setIPv6Labels(newaddress)
+	c.setRetention(newaddress, service)
+	if !reflect.DeepEqual(address.ObjectMeta, newaddress.ObjectMeta) {
+		if _, err := c.IpamClient.IpamV1().IpAddresses(address.Namespace).Update(newaddress); err != nil {
+			return "", err
+		}
+	}
+
+	return address.Status.Address, nil
+}
+
+// Label an IpAddress for the IPAM controller that assigns the IPv6 addresses. The labels of
+// existing IpAddresses are updated if IPV6_IPAM_LABELS has changed; their address is kept.
+func (c *Controller) setIPv6Labels(address *ipamv1.IpAddress) {
+	if address.Labels == nil {
+		address.Labels = map[string]string{}
+	}
+	for k, v := range c.IPv6Labels {
+		address.Labels[k] = v
+	}
+}
+
+// IPAM may assign an IPv4 address if the IpAddress is served by an IPAM controller without
+// an IPv6 network.
+func checkIPv6VIP(vip string) error {
+	if ip := net.ParseIP(vip); ip == nil || ip.To4() != nil {
+		return fmt.Errorf("IPAM assigned '%s' as the IPv6 VIP, which is not an IPv6 address", vip)
+	}
+	return nil
+}
+
+// Remove the IPv6 address of a Service that no longer asks for one.
+func (c *Controller) releaseIPv6VIP(service *corev1.Service) error {
+	address, err := c.IpAddressLister.IpAddresses(service.Namespace).Get(ipv6AddressName(service))
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if !belongsTo(address, service) {
+		return nil
+	}
+
+	log.Infof("releasing ipv6 address '%s-%s' of service '%s-%s'", address.Namespace, address.Name, service.Namespace, service.Name)
+	return c.IpamClient.IpamV1().IpAddresses(address.Namespace).Delete(address.Name, &metav1.DeleteOptions{})
+}
+
+// The Service that requested an IPv6 address.
+func ipv6OwnerOf(address *ipamv1.IpAddress) (string, bool) {
+	if address.Annotations[AnnNxIPFamily] != string(ipv6) {
+		return "", false
+	}
+	if service := address.Annotations[AnnNxRetainedFor]; service != "" {
+		return service, true
+	}
+	for _, ref := range address.OwnerReferences {
+		if ref.Kind == "Service" {
+			return ref.Name, true
+		}
+	}
+	return "", false
+}
+
+// The IP family of a generated ConfigMap; ConfigMaps without the label are for IPv4.
+func configMapFamilyOf(configMap *corev1.ConfigMap) ipFamily {
+	if configMap.Labels[LabelIPFamily] == string(ipv6) {
+		return ipv6
+	}
+	return ipv4
+}
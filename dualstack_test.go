@@ -0,0 +1,163 @@
+package main
+
+import (
+	"github.com/Nexinto/k8s-lbutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net"
+	"testing"
+)
+
+func TestParseFamilies(t *testing.T) {
+	a := assert.New(t)
+
+	for s, expected := range map[string][]ipFamily{
+		"":               {ipv4},
+		"IPv4":           {ipv4},
+		"IPv4,IPv6":      {ipv4, ipv6},
+		" ipv6 , ipv4 ":  {ipv4, ipv6},
+		"IPv4,IPv4,IPv6": {ipv4, ipv6},
+	} {
+		families, err := parseFamilies(s)
+		if a.Nil(err, s) {
+			a.Equal(expected, families, s)
+		}
+	}
+
+	for _, s := range []string{"IPv6", "IPv4,IPv5", "IPv4,"} {
+		_, err := parseFamilies(s)
+		a.NotNil(err, s)
+	}
+}
+
+// Test a dual-stack Service of type LoadBalancer
+func TestDualStack(t *testing.T) {
+	c := testEnvironment()
+	a := assert.New(t)
+
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "myservice",
+			Namespace:   "default",
+			Annotations: map[string]string{AnnNxReqVIPFamilies: "IPv4,IPv6"},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeLoadBalancer,
+			Ports: []corev1.ServicePort{{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80, NodePort: 32080}},
+		},
+	}
+
+	_, err := c.Kubernetes.CoreV1().Services("default").Create(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	// the IPv6 address is requested once the IPv4 address is assigned
+	for i := 0; i < 3; i++ {
+		if err := c.simulate(); !a.Nil(err) {
+			return
+		}
+	}
+
+	address, err := c.IpamClient.IpamV1().IpAddresses("default").Get("myservice-ipv6", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.Equal("IPv6", address.Annotations[AnnNxIPFamily])
+	a.Equal("ipv6", address.Labels["ipam.nexinto.com/network"])
+	a.Equal("2001:db8::10", address.Status.Address)
+
+	ipv4, err := c.IpamClient.IpamV1().IpAddresses("default").Get("myservice", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.Empty(ipv4.Labels["ipam.nexinto.com/network"])
+	a.NotNil(net.ParseIP(ipv4.Status.Address).To4())
+
+	s, err = c.Kubernetes.CoreV1().Services("default").Get("myservice", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.Equal("2001:db8::10", s.Annotations[AnnNxAssignedVIPv6])
+	a.Equal(s.Annotations[lbutil.AnnNxAssignedVIP], s.Annotations[lbutil.AnnNxVIP])
+	a.Equal(s.Annotations[AnnNxAssignedVIPv6], s.Annotations[AnnNxVIPv6])
+	a.Equal([]corev1.LoadBalancerIngress{
+		{IP: s.Annotations[lbutil.AnnNxVIP]},
+		{IP: s.Annotations[AnnNxVIPv6]},
+	}, s.Status.LoadBalancer.Ingress)
+
+	cm, err := c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-myservice-80", metav1.GetOptions{})
+	if a.Nil(err) {
+		a.Equal(s.Annotations[lbutil.AnnNxAssignedVIP], cm.Annotations[AnnVirtualServerIP])
+	}
+
+	cm, err = c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-myservice-80-ipv6", metav1.GetOptions{})
+	if a.Nil(err) {
+		a.Equal("2001:db8::10", cm.Annotations[AnnVirtualServerIP])
+		a.Nil(net.ParseIP(cm.Annotations[AnnVirtualServerIP]).To4())
+		a.Equal("IPv6", cm.Labels[LabelIPFamily])
+	}
+
+	// back to IPv4 only
+	delete(s.Annotations, AnnNxReqVIPFamilies)
+	_, err = c.Kubernetes.CoreV1().Services("default").Update(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	_, err = c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-myservice-80-ipv6", metav1.GetOptions{})
+	a.True(errors.IsNotFound(err))
+	_, err = c.IpamClient.IpamV1().IpAddresses("default").Get("myservice-ipv6", metav1.GetOptions{})
+	a.True(errors.IsNotFound(err))
+
+	s, err = c.Kubernetes.CoreV1().Services("default").Get("myservice", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.Empty(s.Annotations[AnnNxAssignedVIPv6])
+	a.Empty(s.Annotations[AnnNxVIPv6])
+	a.Len(s.Status.LoadBalancer.Ingress, 1)
+}
+
+// Test that a dual-stack Service is invalid if no IPAM controller is configured for IPv6
+func TestDualStackWithoutIPv6IPAM(t *testing.T) {
+	c := testEnvironment()
+	a := assert.New(t)
+
+	c.IPv6Labels = nil
+
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "myservice",
+			Namespace:   "default",
+			Annotations: map[string]string{AnnNxReqVIPFamilies: "IPv4,IPv6"},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80, NodePort: 32080}},
+		},
+	}
+
+	_, err := c.Kubernetes.CoreV1().Services("default").Create(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	s, err = c.Kubernetes.CoreV1().Services("default").Get("myservice", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.Equal(VIPStateInvalid, s.Annotations[AnnNxVIPState])
+	_, err = c.IpamClient.IpamV1().IpAddresses("default").Get("myservice-ipv6", metav1.GetOptions{})
+	a.True(errors.IsNotFound(err))
+}
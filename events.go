@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/Nexinto/k8s-lbutil"
+)
+
+const defaultWarningInterval = 10 * time.Minute
+
+// Remembers the Warning Events emitted for each Service, so a Service that keeps failing
+// in the same way does not get the same Event on every retry. The zero value is ready to use.
+type warningLimiter struct {
+	sync.Mutex
+
+	// identical warnings for a Service are emitted at most once per interval
+	interval time.Duration
+
+	// when each warning was last emitted, by Service key and message
+	emitted map[string]map[string]time.Time
+}
+
+// Returns true if the warning should be emitted now.
+func (l *warningLimiter) allow(key, message string, now time.Time) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	interval := l.interval
+	if interval == 0 {
+		interval = defaultWarningInterval
+	}
+
+	if l.emitted == nil {
+		l.emitted = map[string]map[string]time.Time{}
+	}
+
+	warnings := l.emitted[key]
+	if warnings == nil {
+		warnings = map[string]time.Time{}
+		l.emitted[key] = warnings
+	}
+
+	for m, t := range warnings {
+		if now.Sub(t) >= interval {
+			delete(warnings, m)
+		}
+	}
+
+	if _, ok := warnings[message]; ok {
+		return false
+	}
+	warnings[message] = now
+	return true
+}
+
+// Forget the warnings for a Service, for example when it was deleted.
+func (l *warningLimiter) forget(key string) {
+	l.Lock()
+	defer l.Unlock()
+	delete(l.emitted, key)
+}
+
+// Emit a Warning Event for the Service, unless the same warning was emitted recently.
+func (c *Controller) warn(service *corev1.Service, message string) {
+	if !c.warnings.allow(service.Namespace+"/"+service.Name, message, time.Now()) {
+		log.Debugf("not repeating warning for service '%s-%s': %s", service.Namespace, service.Name, message)
+		return
+	}
+	lbutil.MakeEvent(c.Kubernetes, service, message, true)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWarningLimiter(t *testing.T) {
+	a := assert.New(t)
+
+	l := &warningLimiter{interval: time.Minute}
+	now := time.Now()
+
+	a.True(l.allow("default/a", "failed", now))
+	a.False(l.allow("default/a", "failed", now.Add(30*time.Second)))
+	a.True(l.allow("default/a", "failed differently", now.Add(30*time.Second)))
+	a.True(l.allow("default/b", "failed", now.Add(30*time.Second)))
+	a.True(l.allow("default/a", "failed", now.Add(time.Minute)))
+
+	l.forget("default/b")
+	a.True(l.allow("default/b", "failed", now.Add(40*time.Second)))
+}
+
+// Test that a failure to create a ConfigMap is reported once on the Service, even though it is retried
+func TestConfigMapCreateWarning(t *testing.T) {
+	c := testEnvironment()
+	a := assert.New(t)
+
+	c.Kubernetes.(*fake.Clientset).PrependReactor("create", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("exceeded quota")
+	})
+
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "myservice",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80, NodePort: 32080}},
+		},
+	}
+
+	_, err := c.Kubernetes.CoreV1().Services("default").Create(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	events, err := c.Kubernetes.CoreV1().Events("default").List(metav1.ListOptions{})
+	if !a.Nil(err) {
+		return
+	}
+
+	warnings := 0
+	for _, e := range events.Items {
+		if strings.HasPrefix(e.Message, "Error creating the loadbalancing configuration for port 80") {
+			a.Equal(corev1.EventTypeWarning, e.Type)
+			a.Contains(e.Message, "exceeded quota")
+			warnings++
+		}
+	}
+	a.Equal(1, warnings)
+}
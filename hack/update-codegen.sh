@@ -0,0 +1,15 @@
+#!/bin/bash
+
+# Regenerate the clientset, informers, listers and deepcopy functions for the
+# custom resources in pkg/apis. Requires k8s.io/code-generator in the GOPATH.
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+SCRIPT_ROOT=$(dirname ${BASH_SOURCE})/..
+CODEGEN_PKG=${CODEGEN_PKG:-$(cd ${SCRIPT_ROOT}; ls -d -1 ./vendor/k8s.io/code-generator 2>/dev/null || echo ${GOPATH}/src/k8s.io/code-generator)}
+
+${CODEGEN_PKG}/generate-groups.sh "deepcopy,client,informer,lister" \
+  github.com/Nexinto/k8s-bigip-ipam/pkg/client github.com/Nexinto/k8s-bigip-ipam/pkg/apis \
+  bigip.nexinto.com:v1
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const defaultLivenessThreshold = 5 * time.Minute
+
+// State for the health endpoints. The zero value is ready to use.
+type healthState struct {
+	sync.Mutex
+
+	// the workers are started once the caches are synced
+	running bool
+
+	// the caches are synced and the instance waits for the leader lease
+	standby bool
+
+	// how long the longest running item of each queue has been processed, in seconds
+	longestRunning map[string]float64
+
+	// liveness fails if an item takes longer than this
+	threshold time.Duration
+}
+
+func (h *healthState) setRunning() {
+	h.Lock()
+	defer h.Unlock()
+	h.running = true
+}
+
+func (h *healthState) setStandby() {
+	h.Lock()
+	defer h.Unlock()
+	h.standby = true
+}
+
+// Are the workers running, or is the instance ready to take over as the leader?
+func (h *healthState) isRunning() bool {
+	h.Lock()
+	defer h.Unlock()
+	return h.running || h.standby
+}
+
+func (h *healthState) setLongestRunning(queue string, seconds float64) {
+	h.Lock()
+	defer h.Unlock()
+	if h.longestRunning == nil {
+		h.longestRunning = map[string]float64{}
+	}
+	h.longestRunning[queue] = seconds
+}
+
+// Returns a description of every queue with an item that has been processed for too long.
+func (h *healthState) wedged() []string {
+	h.Lock()
+	defer h.Unlock()
+
+	threshold := h.threshold
+	if threshold == 0 {
+		threshold = defaultLivenessThreshold
+	}
+
+	wedged := []string{}
+	for queue, seconds := range h.longestRunning {
+		if seconds > threshold.Seconds() {
+			wedged = append(wedged, fmt.Sprintf("%s worker busy for %.0fs", queue, seconds))
+		}
+	}
+	sort.Strings(wedged)
+	return wedged
+}
+
+// Sets the longest running processor metric and keeps the value for the liveness check.
+type longestRunningGauge struct {
+	prometheus.Gauge
+	queue  string
+	health *healthState
+}
+
+func (g *longestRunningGauge) Set(seconds float64) {
+	g.Gauge.Set(seconds)
+	g.health.setLongestRunning(g.queue, seconds)
+}
+
+// Ready once the workers are running with synced caches, or the instance is on standby.
+func (c *Controller) ready() (bool, string) {
+	if !c.health.isRunning() {
+		return false, "workers not started"
+	}
+
+	synced := c.cachesSynced()
+	if c.Routes != nil {
+		synced["Route"] = c.Routes.Synced
+	}
+
+	for name, hasSynced := range synced {
+		if !hasSynced() {
+			return false, fmt.Sprintf("%s cache not synced", name)
+		}
+	}
+
+	return true, ""
+}
+
+func (c *Controller) readyz(w http.ResponseWriter, r *http.Request) {
+	if ok, reason := c.ready(); !ok {
+		http.Error(w, reason, http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (c *Controller) healthz(w http.ResponseWriter, r *http.Request) {
+	if wedged := c.health.wedged(); len(wedged) > 0 {
+		http.Error(w, fmt.Sprintf("%v", wedged), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
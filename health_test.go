@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Test the readiness check
+func TestReadyz(t *testing.T) {
+	a := assert.New(t)
+
+	c := &Controller{}
+
+	w := httptest.NewRecorder()
+	c.readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	a.Equal(http.StatusServiceUnavailable, w.Code)
+	a.Contains(w.Body.String(), "workers not started")
+
+	// RunHooks() reports running once the caches are synced and the workers are started
+	c = testEnvironment()
+
+	for i := 0; i < 20 && !c.health.isRunning(); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	w = httptest.NewRecorder()
+	c.readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	a.Equal(http.StatusOK, w.Code)
+}
+
+// Test that the liveness check fails if a worker is stuck
+func TestHealthz(t *testing.T) {
+	c := &Controller{}
+	a := assert.New(t)
+
+	c.health.threshold = time.Minute
+	c.health.setLongestRunning("Service", 10)
+
+	w := httptest.NewRecorder()
+	c.healthz(w, httptest.NewRequest("GET", "/healthz", nil))
+	a.Equal(http.StatusOK, w.Code)
+
+	c.health.setLongestRunning("Service", 120)
+
+	w = httptest.NewRecorder()
+	c.healthz(w, httptest.NewRequest("GET", "/healthz", nil))
+	a.Equal(http.StatusInternalServerError, w.Code)
+	a.Contains(w.Body.String(), "Service worker busy")
+}
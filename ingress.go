@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Nexinto/k8s-lbutil"
+
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+)
+
+const (
+	// The ingress class handled by k8s-bigip-ctlr
+	AnnIngressClass = "kubernetes.io/ingress.class"
+	IngressClassF5  = "f5"
+
+	// The partition for the virtual server created by k8s-bigip-ctlr for an Ingress
+	AnnVirtualServerPartition = "virtual-server.f5.com/partition"
+)
+
+func (c *Controller) IngressCreatedOrUpdated(ingress *extensionsv1beta1.Ingress) (err error) {
+	log.Debugf("processing ingress '%s-%s'", ingress.Namespace, ingress.Name)
+	defer requeueOnError(c.IngressQueue, ingress, &err)
+
+	if !c.handlesIngress(ingress) || (c.RequireTag && ingress.Annotations[AnnNxReqVIP] != "true") || userProvidedIP(ingress) {
+		return c.releaseIngressVIP(ingress)
+	}
+
+	name := ipAddressNameForIngress(ingress)
+
+	address, err := c.IpAddressLister.IpAddresses(ingress.Namespace).Get(name)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		address = &ipamv1.IpAddress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: ingress.Namespace,
+				OwnerReferences: []metav1.OwnerReference{{
+					Kind:       "Ingress",
+					APIVersion: "extensions/v1beta1",
+					Name:       ingress.Name,
+					UID:        ingress.GetUID(),
+				}},
+			},
+		}
+		_, err = c.IpamClient.IpamV1().IpAddresses(ingress.Namespace).Create(address)
+		if err != nil {
+			return err
+		}
+		log.Infof("requested address '%s-%s' for ingress '%s-%s'", address.Namespace, address.Name, ingress.Namespace, ingress.Name)
+		return nil
+	}
+
+	if !ownedByIngress(address, ingress) {
+		return fmt.Errorf("IpAddress '%s' exists, but does not belong to ingress '%s'", address.Name, ingress.Name)
+	}
+
+	vip := address.Status.Address
+
+	newingress := ingress.DeepCopy()
+
+	if vip == "" {
+		// no address (yet); make sure k8s-bigip-ctlr does not use an old one
+		delete(newingress.Annotations, AnnVirtualServerIP)
+		delete(newingress.Annotations, lbutil.AnnNxVIP)
+	} else {
+		if newingress.Annotations == nil {
+			newingress.Annotations = map[string]string{}
+		}
+		newingress.Annotations[AnnVirtualServerIP] = vip
+		if _, ok := newingress.Annotations[AnnVirtualServerPartition]; !ok {
+			newingress.Annotations[AnnVirtualServerPartition] = c.Partition
+		}
+		newingress.Annotations[lbutil.AnnNxVIP] = vip
+	}
+
+	if !reflect.DeepEqual(ingress.Annotations, newingress.Annotations) {
+		log.Infof("setting virtual IP of ingress '%s-%s' to '%s'", ingress.Namespace, ingress.Name, vip)
+		newingress, err = c.Kubernetes.ExtensionsV1beta1().Ingresses(ingress.Namespace).Update(newingress)
+		if err != nil {
+			return err
+		}
+	}
+
+	var lbIngress []corev1.LoadBalancerIngress
+	if vip != "" {
+		lbIngress = []corev1.LoadBalancerIngress{{IP: vip}}
+	}
+
+	if (len(newingress.Status.LoadBalancer.Ingress) > 0 || len(lbIngress) > 0) && !reflect.DeepEqual(newingress.Status.LoadBalancer.Ingress, lbIngress) {
+		newingress = newingress.DeepCopy()
+		newingress.Status.LoadBalancer.Ingress = lbIngress
+		_, err = c.Kubernetes.ExtensionsV1beta1().Ingresses(ingress.Namespace).UpdateStatus(newingress)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Controller) IngressDeleted(ingress *extensionsv1beta1.Ingress) error {
+	log.Debugf("processing deleted ingress '%s-%s'", ingress.Namespace, ingress.Name)
+	return c.deleteIngressAddress(ingress)
+}
+
+// Is the Ingress handled by k8s-bigip-ctlr? Ingresses without an ingress class only
+// with DEFAULT_INGRESS_CLASS.
+func (c *Controller) handlesIngress(ingress *extensionsv1beta1.Ingress) bool {
+	if class := ingress.Annotations[AnnIngressClass]; class != "" {
+		return class == IngressClassF5
+	}
+	return c.DefaultIngressClass
+}
+
+// Was the virtual IP of the Ingress set by the user instead of this controller?
+func userProvidedIP(ingress *extensionsv1beta1.Ingress) bool {
+	ip := ingress.Annotations[AnnVirtualServerIP]
+	return ip != "" && ip != ingress.Annotations[lbutil.AnnNxVIP]
+}
+
+// Release the address of an Ingress that this controller no longer handles, and remove
+// the virtual IP that it set.
+func (c *Controller) releaseIngressVIP(ingress *extensionsv1beta1.Ingress) error {
+	if err := c.deleteIngressAddress(ingress); err != nil {
+		return err
+	}
+
+	vip := ingress.Annotations[lbutil.AnnNxVIP]
+	if vip == "" {
+		return nil
+	}
+
+	log.Infof("removing virtual IP '%s' from ingress '%s-%s'", vip, ingress.Namespace, ingress.Name)
+
+	newingress := ingress.DeepCopy()
+	if newingress.Annotations[AnnVirtualServerIP] == vip {
+		delete(newingress.Annotations, AnnVirtualServerIP)
+	}
+	delete(newingress.Annotations, lbutil.AnnNxVIP)
+
+	newingress, err := c.Kubernetes.ExtensionsV1beta1().Ingresses(ingress.Namespace).Update(newingress)
+	if err != nil {
+		return err
+	}
+
+	if reflect.DeepEqual(newingress.Status.LoadBalancer.Ingress, []corev1.LoadBalancerIngress{{IP: vip}}) {
+		newingress = newingress.DeepCopy()
+		newingress.Status.LoadBalancer.Ingress = nil
+		_, err = c.Kubernetes.ExtensionsV1beta1().Ingresses(ingress.Namespace).UpdateStatus(newingress)
+	}
+	return err
+}
+
+// Delete the IpAddress requested for an Ingress.
+func (c *Controller) deleteIngressAddress(ingress *extensionsv1beta1.Ingress) error {
+	address, err := c.IpAddressLister.IpAddresses(ingress.Namespace).Get(ipAddressNameForIngress(ingress))
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if !ownedByIngress(address, ingress) {
+		return nil
+	}
+
+	log.Infof("releasing address '%s-%s' of ingress '%s-%s'", address.Namespace, address.Name, ingress.Namespace, ingress.Name)
+	err = c.IpamClient.IpamV1().IpAddresses(address.Namespace).Delete(address.Name, &metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Was the address requested for this Ingress, and not for an older Ingress with the same name?
+func ownedByIngress(address *ipamv1.IpAddress, ingress *extensionsv1beta1.Ingress) bool {
+	for _, ref := range address.OwnerReferences {
+		if ref.Kind == "Ingress" && ref.UID == ingress.GetUID() {
+			return true
+		}
+	}
+	return false
+}
+
+// The IpAddress for an Ingress. Service names cannot contain dots, so this cannot collide
+// with the address of a Service.
+func ipAddressNameForIngress(ingress *extensionsv1beta1.Ingress) string {
+	return "ingress." + ingress.Name
+}
+
+// Returns the name of the Ingress that owns an address, if any.
+func ingressOwnerOf(address *ipamv1.IpAddress) (string, bool) {
+	for _, ref := range address.OwnerReferences {
+		if ref.Kind == "Ingress" {
+			return ref.Name, true
+		}
+	}
+	return "", false
+}
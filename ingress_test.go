@@ -0,0 +1,221 @@
+package main
+
+import (
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+	"github.com/Nexinto/k8s-lbutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"testing"
+)
+
+// Test that an Ingress gets a VIP
+func TestIngress(t *testing.T) {
+	c := testEnvironment()
+	a := assert.New(t)
+
+	i := &extensionsv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "myingress",
+			Namespace:   "default",
+			Annotations: map[string]string{AnnIngressClass: IngressClassF5},
+		},
+		Spec: extensionsv1beta1.IngressSpec{
+			Backend: &extensionsv1beta1.IngressBackend{
+				ServiceName: "myservice",
+				ServicePort: intstr.FromInt(80),
+			},
+		},
+	}
+
+	_, err := c.Kubernetes.ExtensionsV1beta1().Ingresses("default").Create(i)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	ia, err := c.IpamClient.IpamV1().IpAddresses("default").Get("ingress.myingress", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+
+	assigned := ia.Status.Address
+	if !a.NotEmpty(assigned) {
+		return
+	}
+
+	i, err = c.Kubernetes.ExtensionsV1beta1().Ingresses("default").Get("myingress", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+
+	a.Equal(assigned, i.Annotations[AnnVirtualServerIP])
+	a.Equal(assigned, i.Annotations[lbutil.AnnNxVIP])
+	a.Equal([]corev1.LoadBalancerIngress{{IP: assigned}}, i.Status.LoadBalancer.Ingress)
+}
+
+// Test that Ingresses for other ingress controllers are ignored
+func TestIngressOtherClass(t *testing.T) {
+	c := testEnvironment()
+	a := assert.New(t)
+
+	i := &extensionsv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "myingress",
+			Namespace:   "default",
+			Annotations: map[string]string{AnnIngressClass: "nginx"},
+		},
+	}
+
+	_, err := c.Kubernetes.ExtensionsV1beta1().Ingresses("default").Create(i)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	_, err = c.IpamClient.IpamV1().IpAddresses("default").Get("ingress.myingress", metav1.GetOptions{})
+	a.NotNil(err)
+}
+
+// Test that Ingresses without an ingress class are only handled with DEFAULT_INGRESS_CLASS
+func TestIngressWithoutClass(t *testing.T) {
+	c := testEnvironment()
+	a := assert.New(t)
+
+	i := &extensionsv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "myingress",
+			Namespace: "default",
+		},
+	}
+
+	_, err := c.Kubernetes.ExtensionsV1beta1().Ingresses("default").Create(i)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	_, err = c.IpamClient.IpamV1().IpAddresses("default").Get("ingress.myingress", metav1.GetOptions{})
+	a.True(errors.IsNotFound(err))
+
+	c.DefaultIngressClass = true
+	c.IngressQueue.Add("default/myingress")
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	_, err = c.IpamClient.IpamV1().IpAddresses("default").Get("ingress.myingress", metav1.GetOptions{})
+	a.Nil(err)
+}
+
+// Test that a virtual IP and partition set by the user are kept, and that the address is released
+func TestIngressUserProvidedIP(t *testing.T) {
+	c := testEnvironment()
+	a := assert.New(t)
+
+	i := &extensionsv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "myingress",
+			Namespace:   "default",
+			Annotations: map[string]string{AnnIngressClass: IngressClassF5, AnnVirtualServerPartition: "mypartition"},
+		},
+	}
+
+	_, err := c.Kubernetes.ExtensionsV1beta1().Ingresses("default").Create(i)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	i, err = c.Kubernetes.ExtensionsV1beta1().Ingresses("default").Get("myingress", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.NotEmpty(i.Annotations[AnnVirtualServerIP])
+	a.Equal("mypartition", i.Annotations[AnnVirtualServerPartition])
+
+	i.Annotations[AnnVirtualServerIP] = "10.99.99.99"
+	_, err = c.Kubernetes.ExtensionsV1beta1().Ingresses("default").Update(i)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	i, err = c.Kubernetes.ExtensionsV1beta1().Ingresses("default").Get("myingress", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.Equal("10.99.99.99", i.Annotations[AnnVirtualServerIP])
+	a.Empty(i.Annotations[lbutil.AnnNxVIP])
+
+	_, err = c.IpamClient.IpamV1().IpAddresses("default").Get("ingress.myingress", metav1.GetOptions{})
+	a.True(errors.IsNotFound(err))
+}
+
+// Test that an Ingress does not use an address that was requested for another Ingress
+func TestIngressForeignAddress(t *testing.T) {
+	c := testEnvironment()
+	a := assert.New(t)
+
+	address := &ipamv1.IpAddress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ingress.myingress",
+			Namespace: "default",
+			OwnerReferences: []metav1.OwnerReference{{
+				Kind:       "Ingress",
+				APIVersion: "extensions/v1beta1",
+				Name:       "myingress",
+				UID:        "old",
+			}},
+		},
+	}
+	address.Status.Address = "10.1.2.3"
+
+	_, err := c.IpamClient.IpamV1().IpAddresses("default").Create(address)
+	if !a.Nil(err) {
+		return
+	}
+
+	i := &extensionsv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "myingress",
+			Namespace:   "default",
+			UID:         "new",
+			Annotations: map[string]string{AnnIngressClass: IngressClassF5},
+		},
+	}
+
+	if _, err := c.Kubernetes.ExtensionsV1beta1().Ingresses("default").Create(i); !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	i, err = c.Kubernetes.ExtensionsV1beta1().Ingresses("default").Get("myingress", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.Empty(i.Annotations[AnnVirtualServerIP])
+	a.Empty(i.Status.LoadBalancer.Ingress)
+}
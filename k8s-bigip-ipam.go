@@ -4,11 +4,15 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
-	"regexp"
+	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/Nexinto/k8s-lbutil"
@@ -18,10 +22,16 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/workqueue"
 
 	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
 	ipamclientset "github.com/Nexinto/k8s-ipam/pkg/client/clientset/versioned"
+
+	bigipclientset "github.com/Nexinto/k8s-bigip-ipam/pkg/client/clientset/versioned"
+
+	routeclientset "github.com/openshift/client-go/route/clientset/versioned"
 )
 
 const (
@@ -37,8 +47,63 @@ const (
 	// VIP Mode (http or tcp; the default is tcp)
 	AnnNxVipMode = "nexinto.com/req-vip-mode"
 
+	// Loadbalancing algorithm (round-robin, least-connections-member, ...)
+	AnnNxBalance = "nexinto.com/vip-balance"
+
+	// Map frontend ports to service ports ("443:8443,80:8080")
+	AnnNxPortMap = "nexinto.com/vip-port-map"
+
+	// Health monitor settings. If none of these are set, the readiness probes of the pods are used.
+	AnnNxHealthPath     = "nexinto.com/vip-health-path"
+	AnnNxHealthProtocol = "nexinto.com/vip-health-protocol"
+	AnnNxHealthSend     = "nexinto.com/vip-health-send"
+	AnnNxHealthInterval = "nexinto.com/vip-health-interval"
+	AnnNxHealthTimeout  = "nexinto.com/vip-health-timeout"
+
+	// Request a VIP (only required if REQUIRE_TAG is set)
+	AnnNxReqVIP = "nexinto.com/req-vip"
+
+	// Comma separated lists of iRules and policies for the virtual servers (schema v0.1.7 and later)
+	AnnNxIRules   = "nexinto.com/vip-irules"
+	AnnNxPolicies = "nexinto.com/vip-policies"
+
+	// Maximum number of concurrent connections per virtual server (schema v0.1.7 and later)
+	AnnNxConnectionLimit = "nexinto.com/vip-connection-limit"
+
+	// BIG-IP partition for the virtual servers of a Service; on a Namespace, the default for its Services
+	AnnNxPartition = "nexinto.com/vip-partition"
+
+	// On a Namespace: comma separated list of the partitions from F5_ALLOWED_PARTITIONS that its Services and
+	// VirtualServerPolicies can select. Only cluster administrators should be allowed to change Namespaces.
+	AnnNxAllowedPartitions = "nexinto.com/vip-allowed-partitions"
+
+	// Set to "true" on a generated ConfigMap to keep the controller from changing it
+	AnnNxPinned = "nexinto.com/vip-pinned"
+
+	// When the configuration in a generated ConfigMap was last changed (RFC 3339)
+	AnnNxWrittenAt = "nexinto.com/vip-written-at"
+
+	// What a Service is waiting for (pending-ipam, pending-bigip, ...)
+	AnnNxVIPState = "nexinto.com/vip-state"
+
+	// Conditions and port readiness of a Service as JSON (see VIPStatus)
+	AnnNxVIPStatus = "nexinto.com/vip-status"
+
 	// bigip provider
 	AnnNxVIPProviderBigIP = "bigip"
+
+	// Generated ConfigMaps are labelled with the CONTROLLER_TAG of the controller instance that manages them
+	LabelControllerTag = "nexinto.com/controller-tag"
+
+	// Generated ConfigMaps are labelled with the name and the port of their Service
+	LabelServiceName = "nexinto.com/service-name"
+	LabelServicePort = "nexinto.com/service-port"
+
+	// Generated ConfigMaps for UDP ports are labelled with the protocol
+	LabelServiceProtocol = "nexinto.com/service-protocol"
+
+	// Name of the ConfigMap index by owning Service
+	IndexConfigMapsByService = "service"
 )
 
 func main() {
@@ -78,7 +143,13 @@ func main() {
 		panic(err.Error())
 	}
 
-	var partition, tag string
+	bigipclient, err := bigipclientset.NewForConfig(clientConfig)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	var partition, tag, balance string
+	var schema F5Schema
 
 	if e := os.Getenv("F5_PARTITION"); e != "" {
 		partition = e
@@ -92,154 +163,573 @@ func main() {
 		tag = "kubernetes"
 	}
 
+	if e := os.Getenv("F5_BALANCE"); e != "" {
+		if F5BalanceAlgorithms[e] {
+			balance = e
+		} else {
+			balance = F5DefaultBalance
+			log.Warnf("unknown loadbalancing algorithm %s, setting to '%s'", e, balance)
+		}
+	} else {
+		balance = F5DefaultBalance
+	}
+
+	if e := os.Getenv("F5_SCHEMA_VERSION"); e != "" {
+		if s, ok := F5Schemas[strings.TrimPrefix(e, "v")]; ok {
+			schema = s
+		} else {
+			schema = F5Schemas[F5DefaultSchemaVersion]
+			log.Warnf("unsupported schema version %s, setting to '%s'", e, schema.Version)
+		}
+	} else {
+		schema = F5Schemas[F5DefaultSchemaVersion]
+	}
+
 	c := &Controller{
-		Kubernetes: clientset,
-		IpamClient: ipamclient,
-		RequireTag: os.Getenv("REQUIRE_TAG") != "",
-		Partition:  partition,
-		Tag:        tag,
+		Kubernetes:  clientset,
+		IpamClient:  ipamclient,
+		BigipClient: bigipclient,
+		RequireTag:  os.Getenv("REQUIRE_TAG") != "",
+		Partition:   partition,
+		Tag:         tag,
+		Balance:     balance,
+		Schema:      schema,
+
+		AllowedPartitions:   map[string]bool{},
+		DefaultIngressClass: os.Getenv("DEFAULT_INGRESS_CLASS") != "",
+	}
+
+	for _, p := range splitList(os.Getenv("F5_ALLOWED_PARTITIONS")) {
+		c.AllowedPartitions[p] = true
+	}
+
+	listenAddress := ":8080"
+	if e := os.Getenv("LISTEN_ADDRESS"); e != "" {
+		listenAddress = e
 	}
 
+	le, err := leaderElectionConfigFromEnv(tag)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	if e := os.Getenv("LIVENESS_THRESHOLD"); e != "" {
+		if d, err := time.ParseDuration(e); err == nil {
+			c.health.threshold = d
+		} else {
+			log.Warnf("invalid liveness threshold %s, using %s", e, defaultLivenessThreshold)
+		}
+	}
+
+	if e := os.Getenv("BIGIP_TIMEOUT"); e != "" {
+		if d, err := time.ParseDuration(e); err == nil {
+			c.BigIPTimeout = d
+		} else {
+			log.Warnf("invalid BIG-IP timeout %s, using %s", e, defaultBigIPTimeout)
+		}
+	}
+
+	if e := os.Getenv("WARNING_INTERVAL"); e != "" {
+		if d, err := time.ParseDuration(e); err == nil {
+			c.warnings.interval = d
+		} else {
+			log.Warnf("invalid warning interval %s, using %s", e, defaultWarningInterval)
+		}
+	}
+
+	if e := os.Getenv("VIP_RETENTION"); e != "" {
+		if d, err := time.ParseDuration(e); err == nil && d >= 0 {
+			c.VIPRetention = d
+		} else {
+			log.Warnf("invalid VIP retention %s, not retaining VIPs", e)
+		}
+	}
+
+	if e := os.Getenv("IPV6_IPAM_LABELS"); e != "" {
+		if l, err := labels.ConvertSelectorToLabelsMap(e); err == nil {
+			c.IPv6Labels = l
+		} else {
+			log.Warnf("invalid IPv6 IPAM labels %s, IPv6 VIPs are not supported", e)
+		}
+	}
+
+	// must be set before the workqueues are created
+	workqueue.SetProvider(queueMetricsProvider{health: &c.health})
+
 	c.Initialize()
-	c.Start()
+	c.InitializeHooks()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", c.healthz)
+	mux.HandleFunc("/readyz", c.readyz)
+
+	go func() {
+		log.Infof("serving metrics and health checks on %s", listenAddress)
+		if err := http.ListenAndServe(listenAddress, mux); err != nil {
+			panic(err.Error())
+		}
+	}()
+
+	if os.Getenv("ENABLE_WEBHOOK") != "" {
+		webhookAddress := ":8443"
+		if e := os.Getenv("WEBHOOK_LISTEN_ADDRESS"); e != "" {
+			webhookAddress = e
+		}
+		certDir := "/etc/webhook/certs"
+		if e := os.Getenv("WEBHOOK_CERT_DIR"); e != "" {
+			certDir = e
+		}
+		go func() {
+			if err := c.ServeWebhook(webhookAddress, certDir); err != nil {
+				panic(err.Error())
+			}
+		}()
+	}
+
+	if os.Getenv("ENABLE_ROUTES") != "" {
+		routeclient, err := routeclientset.NewForConfig(clientConfig)
+		if err != nil {
+			panic(err.Error())
+		}
+		c.InitializeRoutes(routeclient)
+	}
+
+	if le != nil {
+		c.StartWithLeaderElection(le)
+	} else {
+		if err := c.BackfillConfigMapLabels(); err != nil {
+			log.Warnf("error adding labels to existing configmaps: %s", err.Error())
+		}
+		go c.RunRoutes(make(chan struct{}))
+		go c.RunHooks(make(chan struct{}))
+		c.Start()
+	}
 }
 
-func (c *Controller) ServiceCreatedOrUpdated(service *corev1.Service) error {
+func (c *Controller) ServiceCreatedOrUpdated(service *corev1.Service) (err error) {
 	log.Debugf("processing service '%s-%s'", service.Namespace, service.Name)
 
-	ok, needsUpdate, newservice, err := lbutil.EnsureVIP(c.Kubernetes, c.IpamClient, c.IpAddressLister, service, AnnNxVIPProviderBigIP, c.RequireTag)
+	defer func(start time.Time) { observeReconcile("service", start, err) }(time.Now())
+
+	key := service.Namespace + "/" + service.Name
+	defer requeueOnError(c.ServiceQueue, key, &err)
+
+	if c.wantsVIP(service) {
+		requested, err := c.prepareIpAddress(service)
+		if err != nil {
+			c.warn(service, fmt.Sprintf("Error requesting a virtual IP: %s", err.Error()))
+			return fmt.Errorf("error preparing address for service '%s-%s': %s", service.Namespace, service.Name, err.Error())
+		} else if requested {
+			// processed again when IPAM has assigned the address
+			return nil
+		}
+	}
+
+	ok, needsUpdate, newservice, err := c.ensureVIP(service)
 	if err != nil {
+		c.warn(service, fmt.Sprintf("Error requesting a virtual IP: %s", err.Error()))
 		return fmt.Errorf("error getting vip for service '%s-%s': %s", service.Namespace, service.Name, err.Error())
 	} else if !ok {
+		if !needsUpdate {
+			newservice = service.DeepCopy()
+		}
+		if c.wantsVIP(service) {
+			c.serviceStates.set(key, serviceWaitingForIPAM)
+			needsUpdate = setVIPState(newservice, VIPStatePendingIPAM) || needsUpdate
+			needsUpdate = setVIPStatus(newservice, &VIPStatus{Conditions: []VIPCondition{
+				vipCondition(ConditionIPAllocated, false, "Pending", "waiting for the IP address"),
+				vipCondition(ConditionConfigMapsWritten, false, "WaitingForIP", ""),
+				vipCondition(ConditionBigIPConfigured, false, "WaitingForIP", ""),
+			}}) || needsUpdate
+		} else {
+			c.serviceStates.remove(key)
+			c.unwatchPods(service)
+			needsUpdate = setVIPState(newservice, "") || needsUpdate
+			needsUpdate = setVIPStatus(newservice, nil) || needsUpdate
+		}
+		published := []string{service.Annotations[lbutil.AnnNxVIP], service.Annotations[AnnNxVIPv6]}
 		if needsUpdate {
-			_, err = c.Kubernetes.CoreV1().Services(service.Namespace).Update(newservice)
-			return err
+			service, err = c.Kubernetes.CoreV1().Services(service.Namespace).Update(newservice)
+			if err != nil {
+				return err
+			}
 		}
-		return nil
+		return c.removeLoadBalancerIngress(service, published)
 	}
 
-	var ssl bool
-	var mode F5Mode
+	defaults, err := c.defaultsFor(service)
+	var settings *vsSettings
+	if err == nil {
+		settings, err = c.settingsFor(service, defaults)
+	}
+	if err != nil {
+		return c.setInvalid(service, newservice, needsUpdate, err)
+	}
 
-	if service.Annotations[AnnNxVipMode] == "http" {
-		mode = F5ModeHTTP
+	if settings.wantsFamily(ipv6) {
+		vip6, err := c.ensureIPv6VIP(newservice)
+		if err != nil {
+			c.warn(service, fmt.Sprintf("Error requesting an IPv6 virtual IP: %s", err.Error()))
+			return fmt.Errorf("error getting ipv6 vip for service '%s-%s': %s", service.Namespace, service.Name, err.Error())
+		}
+		if vip6 == "" {
+			c.serviceStates.set(key, serviceWaitingForIPAM)
+			needsUpdate = setVIPState(newservice, VIPStatePendingIPAM) || needsUpdate
+			needsUpdate = setVIPStatus(newservice, &VIPStatus{Conditions: []VIPCondition{
+				vipCondition(ConditionIPAllocated, false, "Pending", "waiting for the IPv6 address"),
+				vipCondition(ConditionConfigMapsWritten, false, "WaitingForIP", ""),
+				vipCondition(ConditionBigIPConfigured, false, "WaitingForIP", ""),
+			}}) || needsUpdate
+			if needsUpdate {
+				_, err = c.Kubernetes.CoreV1().Services(service.Namespace).Update(newservice)
+				return err
+			}
+			return nil
+		}
+		if err := checkIPv6VIP(vip6); err != nil {
+			return c.setInvalid(service, newservice, needsUpdate, err)
+		}
+		if newservice.Annotations[AnnNxAssignedVIPv6] != vip6 {
+			newservice.Annotations[AnnNxAssignedVIPv6] = vip6
+			needsUpdate = true
+		}
 	} else {
-		mode = F5ModeTCP
+		if err := c.releaseIPv6VIP(newservice); err != nil {
+			return err
+		}
+		for _, ann := range []string{AnnNxAssignedVIPv6, AnnNxVIPv6} {
+			if _, ok := newservice.Annotations[ann]; ok {
+				delete(newservice.Annotations, ann)
+				needsUpdate = true
+			}
+		}
 	}
 
-	if service.Annotations[AnnNxSSLProfiles] != "" {
-		ssl = true
+	if settings.monitor == nil {
+		var listed bool
+		settings.probeMonitors, listed, err = c.healthMonitorsFromProbes(service)
+		if err != nil {
+			c.warn(service, fmt.Sprintf("Error getting the readiness probes for the health monitors: %s", err.Error()))
+			return fmt.Errorf("error getting readiness probes for service '%s-%s': %s", service.Namespace, service.Name, err.Error())
+		} else if !listed {
+			// processed again when the pods are listed
+			return nil
+		}
 	} else {
-		ssl = false
+		c.unwatchPods(service)
 	}
 
 	service = newservice
 
-	ports := map[int32]bool{} // used for cleaning up later
+	configMapNames := map[string]bool{} // used for cleaning up later
 	activeVips := 0
 	wantedPorts := 0
-
-	for _, port := range service.Spec.Ports {
-		if port.Protocol == corev1.ProtocolUDP {
-			continue
-		}
-		wantedPorts++
-		ports[port.Port] = true
-		mapname := configMapNameFor(service, port.Port)
-		configMap, err := c.ConfigMapLister.ConfigMaps(service.Namespace).Get(mapname)
-		if err == nil {
-			uptodate, newConfigMap, reason := c.configMapUpToDate(service, configMap, ssl, mode, port.Port)
-			if !uptodate {
-				log.Infof("updating configmap '%s-%s' (%s)", configMap.Namespace, configMap.Name, reason)
-				_, err = c.Kubernetes.CoreV1().ConfigMaps(service.Namespace).Update(newConfigMap)
-				if err != nil {
+	pending := []pendingPort{} // ports waiting for k8s-bigip-ctlr
+	portStatus := []VIPPortStatus{}
+
+	for _, family := range settings.families {
+		vip := assignedVIP(service, family)
+
+		for _, port := range service.Spec.Ports {
+			wantedPorts++
+			mapname := configMapNameFor(service, port, family)
+			configMapNames[mapname] = true
+			ready := false
+			configMap, err := c.ConfigMapLister.ConfigMaps(service.Namespace).Get(mapname)
+			if err == nil && configMap.Annotations[AnnNxPinned] == "true" {
+				log.Debugf("configmap '%s-%s' is pinned, not updating", configMap.Namespace, configMap.Name)
+				if configMap.Annotations[AnnVirtualServerIPStatus] == vip {
+					activeVips++
+					ready = true
+				} else {
+					pending = append(pending, pendingPort{port: describePort(port, family), written: writtenAt(configMap)})
+				}
+			} else if err == nil {
+				uptodate, newConfigMap, diff := c.configMapUpToDate(service, configMap, settings, port, family)
+				if !uptodate {
+					log.WithFields(log.Fields{"configmap": configMap.Namespace + "/" + configMap.Name, "changes": diff}).Infof("updating configmap '%s-%s'", configMap.Namespace, configMap.Name)
+					_, err = c.Kubernetes.CoreV1().ConfigMaps(service.Namespace).Update(newConfigMap)
+					if err != nil {
+						c.warn(service, fmt.Sprintf("Error updating the loadbalancing configuration for port %s (ConfigMap '%s'): %s", describePort(port, family), configMap.Name, err.Error()))
+						return err
+					}
+					pending = append(pending, pendingPort{port: describePort(port, family), written: writtenAt(newConfigMap)})
+				} else if configMap.Annotations[AnnVirtualServerIPStatus] == vip {
+					activeVips++ // the bigip ctlr has created the correct VIP
+					ready = true
+				} else {
+					pending = append(pending, pendingPort{port: describePort(port, family), written: writtenAt(configMap)})
+				}
+			} else {
+				if !errors.IsNotFound(err) {
 					return err
 				}
-			} else if configMap.Annotations[AnnVirtualServerIPStatus] == service.Annotations[lbutil.AnnNxAssignedVIP] {
-				activeVips++ // the bigip ctlr has created the correct VIP
-			}
-		} else {
-			if !errors.IsNotFound(err) {
-				return err
-			}
-			configMap = c.configMapFor(service, ssl, mode, port.Port)
-			_, err = c.Kubernetes.CoreV1().ConfigMaps(service.Namespace).Create(configMap)
-			if err != nil {
-				return err
+				configMap = c.configMapFor(service, settings, port, family)
+				configMap.Annotations[AnnNxWrittenAt] = time.Now().UTC().Format(time.RFC3339)
+				pending = append(pending, pendingPort{port: describePort(port, family), written: writtenAt(configMap)})
+				_, err = c.Kubernetes.CoreV1().ConfigMaps(service.Namespace).Create(configMap)
+				if errors.IsAlreadyExists(err) {
+					err = c.adoptConfigMap(service, configMap)
+					if err != nil {
+						c.warn(service, fmt.Sprintf("Error taking over the loadbalancing configuration for port %s (ConfigMap '%s'): %s", describePort(port, family), configMap.Name, err.Error()))
+						return err
+					}
+				} else if err != nil {
+					c.warn(service, fmt.Sprintf("Error creating the loadbalancing configuration for port %s (ConfigMap '%s'): %s", describePort(port, family), configMap.Name, err.Error()))
+					return err
+				} else {
+					log.Infof("created configmap '%s-%s' for service '%s-%s' port %d", configMap.Namespace, configMap.Name, service.Namespace, service.Name, port.Port)
+					if c.restoring.take(configMap.Namespace + "/" + configMap.Name) {
+						lbutil.MakeEvent(c.Kubernetes, service, fmt.Sprintf("Restored deleted loadbalancing configuration for port %s (ConfigMap '%s')", describePort(port, family), configMap.Name), false)
+					}
+				}
 			}
-			log.Infof("created configmap '%s-%s' for service '%s-%s' port %d", configMap.Namespace, configMap.Name, service.Namespace, service.Name, port.Port)
+
+			portStatus = append(portStatus, VIPPortStatus{Port: port.Port, Protocol: protocolOf(port), Family: string(family), ConfigMap: mapname, Ready: ready})
 		}
+	}
+
+	vips := strings.Join(assignedVIPs(newservice, settings.families), "', '")
 
+	if activeVips == wantedPorts && !vipsReady(newservice, settings.families) {
+		log.Infof("loadbalancing for service '%s-%s' is now ready with %d service port(s) on virtual IP '%s'", newservice.Namespace, service.Name, wantedPorts, vips)
+		lbutil.MakeEvent(c.Kubernetes, service, fmt.Sprintf("Loadbalancing with virtual IP '%s' is ready with %d service port(s)", vips, wantedPorts), false)
+		if newservice.Annotations[lbutil.AnnNxVIP] == "" {
+			serviceVIPReady.Observe(time.Since(service.CreationTimestamp.Time).Seconds())
+		}
+		for _, family := range settings.families {
+			newservice.Annotations[readyVIPAnnotation(family)] = assignedVIP(newservice, family)
+		}
+		needsUpdate = true
 	}
 
-	if activeVips == wantedPorts && newservice.Annotations[lbutil.AnnNxVIP] != newservice.Annotations[lbutil.AnnNxAssignedVIP] {
-		log.Infof("loadbalancing for service '%s-%s' is now ready with %d service port(s) on virtual IP '%s'", newservice.Namespace, service.Name, wantedPorts, newservice.Annotations[lbutil.AnnNxAssignedVIP])
-		lbutil.MakeEvent(c.Kubernetes, service, fmt.Sprintf("Loadbalancing with virtual IP '%s' is ready with %d service port(s)", newservice.Annotations[lbutil.AnnNxAssignedVIP], wantedPorts), false)
-		newservice.Annotations[lbutil.AnnNxVIP] = newservice.Annotations[lbutil.AnnNxAssignedVIP]
-		_, err = c.Kubernetes.CoreV1().Services(newservice.Namespace).Update(newservice)
-		if err != nil {
-			return err
+	configured := fmt.Sprintf("k8s-bigip-ctlr has configured %d of %d port(s)", activeVips, wantedPorts)
+	var bigip VIPCondition
+
+	if activeVips == wantedPorts {
+		c.serviceStates.set(key, serviceReady)
+		needsUpdate = setVIPState(newservice, VIPStateReady) || needsUpdate
+		bigip = vipCondition(ConditionBigIPConfigured, true, "Configured", configured)
+	} else {
+		if overdue := c.checkBigIPTimeout(service, pending); len(overdue) > 0 {
+			c.serviceStates.set(key, serviceBigIPTimeout)
+			c.warn(service, fmt.Sprintf("k8s-bigip-ctlr has not configured virtual IP '%s' for port(s) %s within %s", vips, strings.Join(overdue, ", "), c.bigipTimeout()))
+			bigip = vipCondition(ConditionBigIPConfigured, false, "Timeout", configured)
+		} else {
+			c.serviceStates.set(key, serviceWaitingForBigIP)
+			bigip = vipCondition(ConditionBigIPConfigured, false, "Pending", configured)
 		}
+		needsUpdate = setVIPState(newservice, VIPStatePendingBigIP) || needsUpdate
 	}
 
+	needsUpdate = setVIPStatus(newservice, &VIPStatus{
+		Conditions: []VIPCondition{
+			vipCondition(ConditionIPAllocated, true, "Allocated", fmt.Sprintf("virtual IP '%s' assigned", vips)),
+			vipCondition(ConditionConfigMapsWritten, true, "Written", fmt.Sprintf("%d ConfigMap(s) written", wantedPorts)),
+			bigip,
+		},
+		Ports: portStatus,
+	}) || needsUpdate
+
 	// Clean up any leftover configmaps (for example, if the Ports of a Service were changed)
 
-	r := regexp.MustCompile("bigip-([^-]+)-(.*)")
-	configMaps, err := c.ConfigMapLister.List(labels.Everything())
+	configMaps, err := c.ConfigMapIndexer.ByIndex(IndexConfigMapsByService, key)
+	if err != nil {
+		return err
+	}
 
-	for _, configMap := range configMaps {
-		if configMap.Namespace != service.Namespace {
+	for _, o := range configMaps {
+		configMap, ok := o.(*corev1.ConfigMap)
+		if !ok {
 			continue
 		}
-		if configMap.Labels["f5type"] != "virtual-server" {
-			continue
+		if configMap.Labels[LabelControllerTag] != c.Tag {
+			continue // managed by another controller instance
 		}
-
-		m := r.FindStringSubmatch(configMap.Name)
-		if len(m) != 3 {
+		if !ownedBy(configMap, service) {
 			continue
 		}
-
-		if m[1] != service.Name {
+		if configMap.Annotations[AnnNxPinned] == "true" {
 			continue
 		}
 
-		servicePort, err := strconv.Atoi(m[2])
-		if err != nil {
+		if _, ok := servicePortOf(configMap); !ok {
 			continue
 		}
 
-		if ports[int32(servicePort)] {
+		if configMapNames[configMap.Name] {
 			continue
 		}
 		log.Infof("deleting obsolete configmap '%s-%s'", configMap.Namespace, configMap.Name)
 		err = c.Kubernetes.CoreV1().ConfigMaps(configMap.Namespace).Delete(configMap.Name, &metav1.DeleteOptions{})
 		if err != nil {
+			c.warn(service, fmt.Sprintf("Error removing the obsolete loadbalancing configuration in ConfigMap '%s': %s", configMap.Name, err.Error()))
 			return err
 		}
 	}
 
 	if needsUpdate {
-		_, err = c.Kubernetes.CoreV1().Services(service.Namespace).Update(service)
-		return err
+		service, err = c.Kubernetes.CoreV1().Services(service.Namespace).Update(service)
+		if err != nil {
+			return err
+		}
 	}
 
-	return nil
+	return c.updateLoadBalancerStatus(service)
+}
+
+// lbutil.EnsureVIP only considers Services of type NodePort. Services of type LoadBalancer
+// have node ports as well, so they are passed on as NodePort Services and get their type back afterwards.
+func (c *Controller) ensureVIP(service *corev1.Service) (bool, bool, *corev1.Service, error) {
+	if service.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return lbutil.EnsureVIP(c.Kubernetes, c.IpamClient, c.IpAddressLister, service, AnnNxVIPProviderBigIP, c.RequireTag)
+	}
+
+	nodePortService := service.DeepCopy()
+	nodePortService.Spec.Type = corev1.ServiceTypeNodePort
+
+	ok, needsUpdate, newservice, err := lbutil.EnsureVIP(c.Kubernetes, c.IpamClient, c.IpAddressLister, nodePortService, AnnNxVIPProviderBigIP, c.RequireTag)
+	if newservice != nil {
+		newservice.Spec.Type = corev1.ServiceTypeLoadBalancer
+	}
+	return ok, needsUpdate, newservice, err
+}
+
+// Services of type LoadBalancer get their VIP in status.loadBalancer.ingress once the virtual servers are ready.
+// It is removed again while the VIP is not confirmed, or if the Service is no longer of type LoadBalancer.
+func (c *Controller) updateLoadBalancerStatus(service *corev1.Service) error {
+	var ingress []corev1.LoadBalancerIngress
+
+	vip := service.Annotations[lbutil.AnnNxVIP]
+	if service.Spec.Type == corev1.ServiceTypeLoadBalancer && vip != "" && vip == service.Annotations[lbutil.AnnNxAssignedVIP] {
+		ingress = []corev1.LoadBalancerIngress{{IP: vip}}
+		if vip6 := service.Annotations[AnnNxVIPv6]; vip6 != "" && vip6 == service.Annotations[AnnNxAssignedVIPv6] {
+			ingress = append(ingress, corev1.LoadBalancerIngress{IP: vip6})
+		}
+	}
+
+	return c.setLoadBalancerIngress(service, ingress)
+}
+
+// Remove the VIPs published for a Service that is no longer loadbalanced by this controller.
+// Entries of another loadbalancer controller are kept.
+func (c *Controller) removeLoadBalancerIngress(service *corev1.Service, published []string) error {
+	ingress := []corev1.LoadBalancerIngress{}
+	for _, i := range service.Status.LoadBalancer.Ingress {
+		keep := true
+		for _, vip := range published {
+			if vip != "" && i.IP == vip {
+				keep = false
+			}
+		}
+		if keep {
+			ingress = append(ingress, i)
+		}
+	}
+	if len(ingress) == len(service.Status.LoadBalancer.Ingress) {
+		return nil
+	}
+	return c.setLoadBalancerIngress(service, ingress)
+}
+
+func (c *Controller) setLoadBalancerIngress(service *corev1.Service, ingress []corev1.LoadBalancerIngress) error {
+	if len(ingress) == 0 && len(service.Status.LoadBalancer.Ingress) == 0 {
+		return nil
+	}
+	if reflect.DeepEqual(service.Status.LoadBalancer.Ingress, ingress) {
+		return nil
+	}
+
+	log.Infof("setting loadbalancer ingress of service '%s-%s' to %v", service.Namespace, service.Name, ingress)
+
+	newservice := service.DeepCopy()
+	newservice.Status.LoadBalancer.Ingress = ingress
+	_, err := c.Kubernetes.CoreV1().Services(service.Namespace).UpdateStatus(newservice)
+	return err
 }
 
 func (c *Controller) ServiceDeleted(service *corev1.Service) error {
 	log.Debugf("processing deleted service '%s-%s'", service.Namespace, service.Name)
+	c.serviceStates.remove(service.Namespace + "/" + service.Name)
+	c.warnings.forget(service.Namespace + "/" + service.Name)
+	c.unwatchPods(service)
+	return c.releaseRetainedAddresses(service)
+}
+
+// Report an invalid loadbalancing configuration. The Service keeps its VIP, but no virtual servers are created.
+func (c *Controller) setInvalid(service, newservice *corev1.Service, needsUpdate bool, err error) error {
+	log.Warnf("invalid loadbalancing configuration for service '%s-%s': %s", service.Namespace, service.Name, err.Error())
+	c.warn(service, fmt.Sprintf("Invalid loadbalancing configuration: %s", err.Error()))
+	c.serviceStates.remove(service.Namespace + "/" + service.Name)
+	needsUpdate = setVIPState(newservice, VIPStateInvalid) || needsUpdate
+	needsUpdate = setVIPStatus(newservice, &VIPStatus{Conditions: []VIPCondition{
+		vipCondition(ConditionIPAllocated, true, "Allocated", fmt.Sprintf("virtual IP '%s' assigned", newservice.Annotations[lbutil.AnnNxAssignedVIP])),
+		vipCondition(ConditionConfigMapsWritten, false, "InvalidConfiguration", err.Error()),
+		vipCondition(ConditionBigIPConfigured, false, "InvalidConfiguration", ""),
+	}}) || needsUpdate
+	if needsUpdate {
+		_, err = c.Kubernetes.CoreV1().Services(service.Namespace).Update(newservice)
+		return err
+	}
 	return nil
 }
 
-func (c *Controller) IpAddressCreatedOrUpdated(address *ipamv1.IpAddress) error {
+// Whether a Service should be loadbalanced by this controller.
+func (c *Controller) wantsVIP(service *corev1.Service) bool {
+	if service.Spec.Type != corev1.ServiceTypeNodePort && service.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return false
+	}
+	return !c.RequireTag || service.Annotations[AnnNxReqVIP] == "true"
+}
+
+func (c *Controller) IpAddressCreatedOrUpdated(address *ipamv1.IpAddress) (err error) {
 	log.Debugf("processing address '%s-%s'", address.Namespace, address.Name)
+	defer requeueOnError(c.IpAddressQueue, address, &err)
+	if service := address.Annotations[AnnNxRetainedFor]; service != "" {
+		if released, err := c.expireRetainedAddress(address); released || err != nil {
+			return err
+		}
+		c.ServiceQueue.Add(address.Namespace + "/" + service)
+		return nil
+	}
+	if ingress, ok := ingressOwnerOf(address); ok {
+		c.IngressQueue.Add(address.Namespace + "/" + ingress)
+		return nil
+	}
+	if group, ok := routeGroupOfAddress(address); ok {
+		if c.Routes != nil {
+			c.Routes.Queue.Add(address.Namespace + "/" + group)
+		}
+		return nil
+	}
+	if service, ok := ipv6OwnerOf(address); ok {
+		c.ServiceQueue.Add(address.Namespace + "/" + service)
+		return nil
+	}
 	lbutil.IpAddressCreatedOrUpdated(c.ServiceQueue, address)
 	return nil
 }
 
 func (c *Controller) IpAddressDeleted(address *ipamv1.IpAddress) error {
 	log.Debugf("processing deleted address '%s-%s'", address.Namespace, address.Name)
+	if ingress, ok := ingressOwnerOf(address); ok {
+		c.IngressQueue.Add(address.Namespace + "/" + ingress)
+		return nil
+	}
+	if group, ok := routeGroupOfAddress(address); ok {
+		if c.Routes != nil {
+			c.Routes.Queue.Add(address.Namespace + "/" + group)
+		}
+		return nil
+	}
+	if service, ok := ipv6OwnerOf(address); ok {
+		c.ServiceQueue.Add(address.Namespace + "/" + service)
+		return nil
+	}
+	if service := address.Annotations[AnnNxRetainedFor]; service != "" {
+		c.ServiceQueue.Add(address.Namespace + "/" + service)
+	}
 	return lbutil.IpAddressDeleted(c.Kubernetes, c.ServiceLister, address)
 }
 
@@ -264,26 +754,387 @@ func (c *Controller) ConfigMapCreatedOrUpdated(configMap *corev1.ConfigMap) erro
 	return nil
 }
 
-func (c *Controller) mkF5Config(service *corev1.Service, ssl bool, mode F5Mode, port, servicePort int32) (f5 *F5VirtualServerConfig) {
+// A generated ConfigMap was deleted. If its Service still wants it, wake up the Service
+// at once so the virtual server is restored before the next resync.
+func (c *Controller) ConfigMapDeleted(configMap *corev1.ConfigMap) error {
+	log.Debugf("processing deleted configmap '%s-%s'", configMap.Namespace, configMap.Name)
+
+	servicePort, ok := servicePortOf(configMap)
+	if !ok {
+		return nil
+	}
+
+	for _, ref := range configMap.OwnerReferences {
+		if ref.Kind != "Service" || ref.APIVersion != "v1" {
+			continue
+		}
+
+		service, err := c.ServiceLister.Services(configMap.Namespace).Get(ref.Name)
+		if errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if service.UID != ref.UID || service.DeletionTimestamp != nil {
+			continue
+		}
+
+		for _, port := range service.Spec.Ports {
+			if port.Port == servicePort && protocolOf(port) == configMapProtocolOf(configMap) && wantsFamilyOf(service, configMap) {
+				log.Infof("configmap '%s-%s' of service '%s-%s' was deleted, restoring", configMap.Namespace, configMap.Name, service.Namespace, service.Name)
+				c.restoring.mark(configMap.Namespace + "/" + configMap.Name)
+				c.ServiceQueue.Add(service.Namespace + "/" + service.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Remembers the ConfigMaps that were deleted while still in use, so the Service
+// can get an Event when they are recreated. The zero value is ready to use.
+type configMapRestorer struct {
+	sync.Mutex
+	keys map[string]bool
+}
+
+func (r *configMapRestorer) mark(key string) {
+	r.Lock()
+	defer r.Unlock()
+	if r.keys == nil {
+		r.keys = map[string]bool{}
+	}
+	r.keys[key] = true
+}
+
+// Returns true (once) if the ConfigMap was marked.
+func (r *configMapRestorer) take(key string) bool {
+	r.Lock()
+	defer r.Unlock()
+	if !r.keys[key] {
+		return false
+	}
+	delete(r.keys, key)
+	return true
+}
+
+// Settings for the virtual servers of a Service, derived from its annotations.
+type vsSettings struct {
+	ssl         bool
+	sslProfiles string
+	mode        F5Mode
+	balance     string
+
+	// health monitor configured by annotations, used for all ports
+	monitor *F5HealthMonitor
+
+	// health monitors derived from readiness probes, by service port
+	probeMonitors map[int32]F5HealthMonitor
+
+	partition string
+
+	// frontend (virtual server) port, by service port
+	frontendPorts map[int32]int32
+
+	// IP families of the VIPs
+	families []ipFamily
+
+	iRules          []string
+	policies        []string
+	connectionLimit int32
+}
+
+// Collect and validate the loadbalancing settings for a Service.
+// The defaults come from the VirtualServerPolicy and the Namespace of the Service.
+func (c *Controller) settingsFor(service *corev1.Service, defaults map[string]string) (*vsSettings, error) {
+	settings := &vsSettings{
+		balance:     c.balanceFor(service, defaults),
+		sslProfiles: annotationWithDefault(service, defaults, AnnNxSSLProfiles),
+	}
+
+	partition, err := c.partitionFor(service, defaults)
+	if err != nil {
+		return nil, err
+	}
+	settings.partition = partition
+
+	// an empty annotation clears the default of the Namespace or policy
+	switch mode := annotationWithDefault(service, defaults, AnnNxVipMode); mode {
+	case string(F5ModeHTTP):
+		settings.mode = F5ModeHTTP
+	case string(F5ModeTCP), "":
+		settings.mode = F5ModeTCP
+	default:
+		return nil, fmt.Errorf("unknown mode '%s' in annotation %s, expected %s or %s", mode, AnnNxVipMode, F5ModeHTTP, F5ModeTCP)
+	}
+
+	if settings.sslProfiles != "" {
+		for _, profile := range strings.Split(settings.sslProfiles, ",") {
+			if !sslProfileRegexp.MatchString(profile) {
+				return nil, fmt.Errorf("malformed SSL profile '%s' in annotation %s, expected a comma separated list of profile paths like Common/mysite", profile, AnnNxSSLProfiles)
+			}
+		}
+		settings.ssl = true
+	} else {
+		settings.ssl = false
+	}
+
+	if !F5BalanceAlgorithms[settings.balance] {
+		return nil, fmt.Errorf("unknown loadbalancing algorithm '%s' in annotation %s", settings.balance, AnnNxBalance)
+	}
+
+	monitor, err := healthMonitorFromAnnotations(annotationsWithDefaults(service, defaults))
+	if err != nil {
+		return nil, err
+	}
+	settings.monitor = monitor
+
+	settings.frontendPorts, err = frontendPortsFor(service, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	settings.families, err = parseFamilies(service.Annotations[AnnNxReqVIPFamilies])
+	if err != nil {
+		return nil, err
+	}
+	if settings.wantsFamily(ipv6) && len(c.IPv6Labels) == 0 {
+		return nil, fmt.Errorf("IPv6 VIPs are not supported, IPV6_IPAM_LABELS is not set")
+	}
+
+	schema := c.schema()
+
+	for ann, supported := range map[string]bool{
+		AnnNxIRules:          schema.IRules,
+		AnnNxPolicies:        schema.Policies,
+		AnnNxConnectionLimit: schema.ConnectionLimit,
+	} {
+		if service.Annotations[ann] != "" && !supported {
+			return nil, fmt.Errorf("annotation %s is not supported with F5 schema version %s", ann, schema.Version)
+		}
+	}
+
+	settings.iRules = splitList(service.Annotations[AnnNxIRules])
+	settings.policies = splitList(service.Annotations[AnnNxPolicies])
+
+	if l := service.Annotations[AnnNxConnectionLimit]; l != "" {
+		limit, err := strconv.Atoi(strings.TrimSpace(l))
+		if err != nil || limit < 1 {
+			return nil, fmt.Errorf("invalid connection limit '%s' in annotation %s", l, AnnNxConnectionLimit)
+		}
+		settings.connectionLimit = int32(limit)
+	}
+
+	return settings, nil
+}
+
+// The partition from the Service annotation or the defaults, or the default partition.
+// Other partitions than the default must be allowed with F5_ALLOWED_PARTITIONS and for
+// the Namespace of the Service.
+func (c *Controller) partitionFor(service *corev1.Service, defaults map[string]string) (string, error) {
+	partition := annotationWithDefault(service, defaults, AnnNxPartition)
+	if partition == "" || partition == c.Partition {
+		return c.Partition, nil
+	}
+	if !c.AllowedPartitions[partition] {
+		return "", fmt.Errorf("partition '%s' in annotation %s is not allowed", partition, AnnNxPartition)
+	}
+
+	namespace, err := c.namespaceOf(service)
+	if err != nil {
+		return "", err
+	}
+	if !partitionAllowedIn(namespace, partition) {
+		return "", fmt.Errorf("partition '%s' in annotation %s is not allowed in namespace '%s', see annotation %s", partition, AnnNxPartition, service.Namespace, AnnNxAllowedPartitions)
+	}
+	return partition, nil
+}
+
+// A Namespace can use its default partition and the partitions listed in AnnNxAllowedPartitions.
+func partitionAllowedIn(namespace *corev1.Namespace, partition string) bool {
+	if namespace.Annotations[AnnNxPartition] == partition {
+		return true
+	}
+	for _, p := range splitList(namespace.Annotations[AnnNxAllowedPartitions]) {
+		if p == partition {
+			return true
+		}
+	}
+	return false
+}
+
+// The schema version to render; the default if none was configured.
+func (c *Controller) schema() F5Schema {
+	if c.Schema.Version == "" {
+		return F5Schemas[F5DefaultSchemaVersion]
+	}
+	return c.Schema
+}
+
+// Split a comma separated list, ignoring empty elements.
+func splitList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// Choose the frontend port for every service port. Ports from the port map annotation are used as they are.
+// Otherwise, a HTTP mode Service with a single port is offered on 80 (or 443 with SSL) and all other ports
+// keep the port number of the service port.
+func frontendPortsFor(service *corev1.Service, settings *vsSettings) (map[int32]int32, error) {
+	servicePorts := map[int32]bool{}
+	for _, port := range service.Spec.Ports {
+		if port.Protocol == corev1.ProtocolUDP {
+			continue
+		}
+		servicePorts[port.Port] = true
+	}
+
+	portMap, err := parsePortMap(service.Annotations[AnnNxPortMap])
+	if err != nil {
+		return nil, err
+	}
+
+	frontendPorts := map[int32]int32{}
+
+	for servicePort, frontendPort := range portMap {
+		if !servicePorts[servicePort] {
+			return nil, fmt.Errorf("port %d in annotation %s is not a TCP port of the service", servicePort, AnnNxPortMap)
+		}
+		frontendPorts[servicePort] = frontendPort
+	}
+
+	for servicePort := range servicePorts {
+		if _, ok := frontendPorts[servicePort]; ok {
+			continue
+		}
+		if settings.mode == F5ModeHTTP && len(servicePorts) == 1 {
+			if settings.ssl {
+				frontendPorts[servicePort] = 443
+			} else {
+				frontendPorts[servicePort] = 80
+			}
+		} else {
+			frontendPorts[servicePort] = servicePort
+		}
+	}
+
+	used := map[int32]int32{}
+	for servicePort, frontendPort := range frontendPorts {
+		if other, ok := used[frontendPort]; ok {
+			return nil, fmt.Errorf("service ports %d and %d both use frontend port %d", other, servicePort, frontendPort)
+		}
+		used[frontendPort] = servicePort
+	}
+
+	return frontendPorts, nil
+}
+
+// Parse a port map ("443:8443,80:8080") into a map of service port to frontend port.
+func parsePortMap(ann string) (map[int32]int32, error) {
+	portMap := map[int32]int32{}
+
+	if strings.TrimSpace(ann) == "" {
+		return portMap, nil
+	}
+
+	for _, entry := range strings.Split(ann, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid entry '%s' in annotation %s, expected FRONTENDPORT:SERVICEPORT", entry, AnnNxPortMap)
+		}
+		frontendPort, err := parsePort(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry '%s' in annotation %s: %s", entry, AnnNxPortMap, err.Error())
+		}
+		servicePort, err := parsePort(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry '%s' in annotation %s: %s", entry, AnnNxPortMap, err.Error())
+		}
+		if _, ok := portMap[servicePort]; ok {
+			return nil, fmt.Errorf("service port %d is mapped more than once in annotation %s", servicePort, AnnNxPortMap)
+		}
+		portMap[servicePort] = frontendPort
+	}
+
+	return portMap, nil
+}
+
+func parsePort(s string) (int32, error) {
+	p, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not a port number", s)
+	}
+	if p < 1 || p > 65535 {
+		return 0, fmt.Errorf("port %d is out of range", p)
+	}
+	return int32(p), nil
+}
+
+// The loadbalancing algorithm for a Service; the annotation (or its default from the policy or
+// Namespace) overrides the controller default.
+func (c *Controller) balanceFor(service *corev1.Service, defaults map[string]string) string {
+	if b := annotationWithDefault(service, defaults, AnnNxBalance); b != "" {
+		return b
+	}
+	if c.Balance != "" {
+		return c.Balance
+	}
+	return F5DefaultBalance
+}
+
+func (c *Controller) mkF5Config(service *corev1.Service, settings *vsSettings, port int32, servicePort corev1.ServicePort) (f5 *F5VirtualServerConfig) {
+
+	udp := protocolOf(servicePort) == corev1.ProtocolUDP
+
+	mode := settings.mode
+	if udp {
+		mode = F5ModeUDP
+	}
 
 	f5 = &F5VirtualServerConfig{
 		VirtualServer: F5VirtualServer{
 			Frontend: F5Frontend{
-				Balance:        "round-robin",
+				Balance:        settings.balance,
 				Mode:           mode,
-				Partition:      c.Partition,
+				Partition:      settings.partition,
 				VirtualAddress: F5VirtualAddress{Port: port},
 			},
-			Backend: F5Backend{ServiceName: service.Name, ServicePort: servicePort},
+			Backend: F5Backend{ServiceName: service.Name, ServicePort: servicePort.Port},
 		},
 	}
 
-	if ssl {
+	// the http and tcp health monitors cannot check UDP ports
+	if !udp {
+		if settings.monitor != nil {
+			f5.VirtualServer.Backend.HealthMonitors = []F5HealthMonitor{*settings.monitor}
+		} else if m, ok := settings.probeMonitors[servicePort.Port]; ok {
+			f5.VirtualServer.Backend.HealthMonitors = []F5HealthMonitor{m}
+		}
+	}
+
+	// only render the fields the schema knows about
+	schema := c.schema()
+	if schema.IRules {
+		f5.VirtualServer.Frontend.IRules = settings.iRules
+	}
+	if schema.Policies {
+		f5.VirtualServer.Frontend.Policies = settings.policies
+	}
+	if schema.ConnectionLimit {
+		f5.VirtualServer.Frontend.ConnectionLimit = settings.connectionLimit
+	}
+
+	if settings.ssl && !udp {
 		f5.VirtualServer.Frontend.SSLProfile = &F5SSLProfile{}
-		ann := strings.Split(service.Annotations[AnnNxSSLProfiles], ",")
+		ann := strings.Split(settings.sslProfiles, ",")
 
 		if len(ann) == 1 {
-			f5.VirtualServer.Frontend.SSLProfile.SSLProfileName = service.Annotations[AnnNxSSLProfiles]
+			f5.VirtualServer.Frontend.SSLProfile.SSLProfileName = settings.sslProfiles
 		} else if len(ann) > 1 {
 			f5.VirtualServer.Frontend.SSLProfile.SSLProfileNames = ann
 		}
@@ -291,32 +1142,31 @@ func (c *Controller) mkF5Config(service *corev1.Service, ssl bool, mode F5Mode,
 	return
 }
 
-func (c *Controller) configMapFor(service *corev1.Service, ssl bool, mode F5Mode, servicePort int32) *corev1.ConfigMap {
-
-	var port int32
+func (c *Controller) configMapFor(service *corev1.Service, settings *vsSettings, servicePort corev1.ServicePort, family ipFamily) *corev1.ConfigMap {
 
-	if mode == F5ModeTCP {
-		port = servicePort
-	} else {
-		if ssl {
-			port = 443
-		} else {
-			port = 80
-		}
+	// the frontend ports can only be changed for TCP ports
+	port := servicePort.Port
+	if protocolOf(servicePort) == corev1.ProtocolTCP {
+		port = settings.frontendPorts[servicePort.Port]
 	}
 
-	mapname := configMapNameFor(service, port)
+	mapname := configMapNameFor(service, servicePort, family)
 
-	f5Config := c.mkF5Config(service, ssl, mode, port, servicePort)
+	f5Config := c.mkF5Config(service, settings, port, servicePort)
 	f5ConfigM, _ := json.Marshal(f5Config)
 
-	return &corev1.ConfigMap{
+	configMap := &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      mapname,
 			Namespace: service.Namespace,
-			Labels:    map[string]string{"f5type": "virtual-server"},
+			Labels: map[string]string{
+				"f5type":           "virtual-server",
+				LabelControllerTag: c.Tag,
+				LabelServiceName:   service.Name,
+				LabelServicePort:   strconv.Itoa(int(servicePort.Port)),
+			},
 			Annotations: map[string]string{
-				AnnVirtualServerIP: service.Annotations[lbutil.AnnNxAssignedVIP],
+				AnnVirtualServerIP: assignedVIP(service, family),
 			},
 			OwnerReferences: []metav1.OwnerReference{{
 				Kind:       "Service",
@@ -326,27 +1176,214 @@ func (c *Controller) configMapFor(service *corev1.Service, ssl bool, mode F5Mode
 			}},
 		},
 		Data: map[string]string{
-			"schema": "f5schemadb://bigip-virtual-server_v0.1.3.json",
+			"schema": c.schema().URL(),
 			"data":   string(f5ConfigM),
 		},
 	}
+
+	if protocolOf(servicePort) == corev1.ProtocolUDP {
+		configMap.Labels[LabelServiceProtocol] = string(corev1.ProtocolUDP)
+	}
+	if family == ipv6 {
+		configMap.Labels[LabelIPFamily] = string(ipv6)
+	}
+
+	return configMap
+}
+
+func (c *Controller) configMapUpToDate(service *corev1.Service, configMap *corev1.ConfigMap, settings *vsSettings, servicePort corev1.ServicePort, family ipFamily) (bool, *corev1.ConfigMap, []string) {
+	wantedConfigMap := c.configMapFor(service, settings, servicePort, family)
+
+	diff := configMapDiff(configMap, wantedConfigMap)
+
+	// only changes to the configuration restart the timeout for k8s-bigip-ctlr
+	if len(diff) == 0 && configMap.Annotations[AnnNxWrittenAt] != "" {
+		wantedConfigMap.Annotations[AnnNxWrittenAt] = configMap.Annotations[AnnNxWrittenAt]
+	} else {
+		wantedConfigMap.Annotations[AnnNxWrittenAt] = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	if configMap.Annotations[AnnVirtualServerIPStatus] != assignedVIP(service, family) {
+		diff = append(diff, fmt.Sprintf("vip changes from %s to %s", configMap.Annotations[AnnVirtualServerIPStatus], assignedVIP(service, family)))
+	}
+
+	return len(diff) == 0, wantedConfigMap, diff
+}
+
+// The ConfigMap informer only sees the virtual server ConfigMaps of this controller instance.
+func (c *Controller) ConfigMapListOptions(options *metav1.ListOptions) {
+	options.LabelSelector = labels.Set{"f5type": "virtual-server", LabelControllerTag: c.Tag}.String()
+}
+
+// Generated ConfigMaps are indexed by the key (namespace/name) of the Service that owns them.
+func (c *Controller) ConfigMapIndexers() cache.Indexers {
+	return cache.Indexers{IndexConfigMapsByService: configMapServiceIndexFunc}
+}
+
+func configMapServiceIndexFunc(obj interface{}) ([]string, error) {
+	configMap, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		return nil, fmt.Errorf("expected ConfigMap but got %T", obj)
+	}
+	keys := []string{}
+	for _, ref := range configMap.OwnerReferences {
+		if ref.Kind == "Service" {
+			keys = append(keys, configMap.Namespace+"/"+ref.Name)
+		}
+	}
+	return keys, nil
+}
+
+// Called if the ConfigMap for a Service port exists, but is not in the cache. This happens
+// for ConfigMaps created before they were labelled with the controller tag and for ConfigMaps
+// of other controller instances. Take over the ConfigMap if it belongs to the Service.
+func (c *Controller) adoptConfigMap(service *corev1.Service, wanted *corev1.ConfigMap) error {
+	existing, err := c.Kubernetes.CoreV1().ConfigMaps(wanted.Namespace).Get(wanted.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	if tag, ok := existing.Labels[LabelControllerTag]; ok && tag != c.Tag {
+		log.Warnf("configmap '%s-%s' for service '%s-%s' is managed by controller instance '%s'", existing.Namespace, existing.Name, service.Namespace, service.Name, tag)
+		c.warn(service, fmt.Sprintf("ConfigMap '%s' is managed by another controller instance ('%s')", existing.Name, tag))
+		return nil
+	}
+
+	if !ownedBy(existing, service) {
+		log.Warnf("configmap '%s-%s' exists, but does not belong to service '%s-%s'", existing.Namespace, existing.Name, service.Namespace, service.Name)
+		c.warn(service, fmt.Sprintf("ConfigMap '%s' exists, but does not belong to this Service", existing.Name))
+		return nil
+	}
+
+	log.Infof("adopting configmap '%s-%s' for service '%s-%s'", existing.Namespace, existing.Name, service.Namespace, service.Name)
+	newConfigMap := wanted.DeepCopy()
+	newConfigMap.ResourceVersion = existing.ResourceVersion
+	_, err = c.Kubernetes.CoreV1().ConfigMaps(wanted.Namespace).Update(newConfigMap)
+	return err
+}
+
+// The service port of a generated ConfigMap.
+func servicePortOf(configMap *corev1.ConfigMap) (int32, bool) {
+	port, err := strconv.Atoi(configMap.Labels[LabelServicePort])
+	if err != nil {
+		return 0, false
+	}
+	return int32(port), true
 }
 
-func (c *Controller) configMapUpToDate(service *corev1.Service, configMap *corev1.ConfigMap, ssl bool, mode F5Mode, servicePort int32) (bool, *corev1.ConfigMap, string) {
-	var reason string
-	wantedConfigMap := c.configMapFor(service, ssl, mode, servicePort)
+// The protocol of the service port of a generated ConfigMap; ConfigMaps without the label are for TCP ports.
+func configMapProtocolOf(configMap *corev1.ConfigMap) corev1.Protocol {
+	if configMap.Labels[LabelServiceProtocol] == string(corev1.ProtocolUDP) {
+		return corev1.ProtocolUDP
+	}
+	return corev1.ProtocolTCP
+}
+
+// The protocol of a service port. Every port that is not a UDP port is loadbalanced as a TCP port.
+func protocolOf(port corev1.ServicePort) corev1.Protocol {
+	if port.Protocol == corev1.ProtocolUDP {
+		return corev1.ProtocolUDP
+	}
+	return corev1.ProtocolTCP
+}
 
-	if configMap.Data["data"] != wantedConfigMap.Data["data"] {
-		reason = "f5Config changed "
+// The port number, followed by /udp for UDP ports and (IPv6) for IPv6 VIPs.
+func describePort(port corev1.ServicePort, family ipFamily) string {
+	s := strconv.Itoa(int(port.Port))
+	if protocolOf(port) == corev1.ProtocolUDP {
+		s += "/udp"
 	}
+	if family == ipv6 {
+		s += " (IPv6)"
+	}
+	return s
+}
+
+// ConfigMaps created by older versions are not labelled with the controller tag, the Service name
+// and the service port, so they are not seen by the informer and cannot be cleaned up. Add the
+// labels to every ConfigMap owned by a Service this instance loadbalances and named like one of
+// its ConfigMaps; the port is taken from the name. ConfigMaps created by hand or for a Service
+// that this instance leaves alone are not touched.
+func (c *Controller) BackfillConfigMapLabels() error {
+	configMaps, err := c.Kubernetes.CoreV1().ConfigMaps(metav1.NamespaceAll).List(metav1.ListOptions{
+		LabelSelector: labels.Set{"f5type": "virtual-server"}.String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, configMap := range configMaps.Items {
+		if tag, ok := configMap.Labels[LabelControllerTag]; ok && tag != c.Tag {
+			continue
+		}
+		if _, ok := servicePortOf(&configMap); ok && configMap.Labels[LabelControllerTag] == c.Tag && configMap.Labels[LabelServiceName] != "" {
+			continue
+		}
+
+		var serviceName string
+		for _, ref := range configMap.OwnerReferences {
+			if ref.Kind == "Service" {
+				serviceName = ref.Name
+			}
+		}
+		if serviceName == "" {
+			continue
+		}
+
+		prefix := "bigip-" + serviceName + "-"
+		if !strings.HasPrefix(configMap.Name, prefix) {
+			continue
+		}
+		port, err := strconv.Atoi(strings.TrimPrefix(configMap.Name, prefix))
+		if err != nil {
+			continue
+		}
 
-	if configMap.Annotations[AnnVirtualServerIPStatus] != service.Annotations[lbutil.AnnNxAssignedVIP] {
-		reason = reason + fmt.Sprintf("vip changes from %s to %s ", configMap.Annotations[AnnVirtualServerIPStatus], service.Annotations[lbutil.AnnNxAssignedVIP])
+		service, err := c.Kubernetes.CoreV1().Services(configMap.Namespace).Get(serviceName, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if !ownedBy(&configMap, service) || !c.wantsVIP(service) {
+			log.Debugf("not adding labels to configmap '%s-%s', service '%s-%s' is not managed by this controller", configMap.Namespace, configMap.Name, service.Namespace, service.Name)
+			continue
+		}
+
+		newConfigMap := configMap.DeepCopy()
+		newConfigMap.Labels[LabelControllerTag] = c.Tag
+		newConfigMap.Labels[LabelServiceName] = serviceName
+		newConfigMap.Labels[LabelServicePort] = strconv.Itoa(port)
+
+		log.Infof("adding labels to configmap '%s-%s'", configMap.Namespace, configMap.Name)
+		_, err = c.Kubernetes.CoreV1().ConfigMaps(configMap.Namespace).Update(newConfigMap)
+		if err != nil {
+			return err
+		}
 	}
 
-	return reason == "", wantedConfigMap, reason
+	return nil
 }
 
-func configMapNameFor(service *corev1.Service, port int32) string {
-	return fmt.Sprintf("bigip-%s-%d", service.Name, port)
+// Is the object owned by the Service?
+func ownedBy(o metav1.Object, service *corev1.Service) bool {
+	for _, ref := range o.GetOwnerReferences() {
+		if ref.Kind == "Service" && ref.UID == service.GetUID() {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigMaps for UDP ports have the suffix -udp, so they don't collide with a TCP port with the same number.
+// ConfigMaps for IPv6 VIPs have the suffix -ipv6.
+func configMapNameFor(service *corev1.Service, port corev1.ServicePort, family ipFamily) string {
+	name := fmt.Sprintf("bigip-%s-%d", service.Name, port.Port)
+	if protocolOf(port) == corev1.ProtocolUDP {
+		name += "-udp"
+	}
+	if family == ipv6 {
+		name += "-ipv6"
+	}
+	return name
 }
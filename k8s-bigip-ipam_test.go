@@ -2,6 +2,8 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	bigipfake "github.com/Nexinto/k8s-bigip-ipam/pkg/client/clientset/versioned/fake"
 	ipamfake "github.com/Nexinto/k8s-ipam/pkg/client/clientset/versioned/fake"
 	"github.com/Nexinto/k8s-lbutil"
 	log "github.com/sirupsen/logrus"
@@ -10,8 +12,9 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes/fake"
-	"k8s.io/client-go/tools/cache"
+	"strings"
 	"testing"
 	"time"
 )
@@ -22,9 +25,12 @@ func testEnvironment() *Controller {
 	log.SetLevel(log.DebugLevel)
 
 	c := &Controller{
-		Kubernetes: fake.NewSimpleClientset(),
-		IpamClient: ipamfake.NewSimpleClientset(),
-		RequireTag: false,
+		Kubernetes:  fake.NewSimpleClientset(),
+		IpamClient:  ipamfake.NewSimpleClientset(),
+		BigipClient: bigipfake.NewSimpleClientset(),
+		RequireTag:  false,
+		Tag:         "kubernetes",
+		IPv6Labels:  map[string]string{"ipam.nexinto.com/network": "ipv6"},
 	}
 
 	c.Kubernetes.CoreV1().Namespaces().Create(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
@@ -49,15 +55,16 @@ func testEnvironment() *Controller {
 	})
 
 	c.Initialize()
+	c.InitializeHooks()
 	go c.Start()
 
 	stopCh := make(chan struct{})
 
-	go c.Run(stopCh)
+	go c.RunHooks(stopCh)
 
 	log.Debug("waiting for cache sync")
 
-	if !cache.WaitForCacheSync(stopCh, c.ServiceSynced, c.ConfigMapSynced, c.IpAddressSynced) {
+	if !c.waitForCaches(stopCh) {
 		panic("Timed out waiting for caches to sync")
 	}
 
@@ -90,13 +97,57 @@ func (c *Controller) simBigIpCtlr() error {
 	return nil
 }
 
+// Simulate an IPAM controller with an IPv6 network that assigns addresses to the IpAddresses
+// labelled with IPv6Labels. It runs before lbutil.SimIPAM, which assigns IPv4 addresses to all
+// IpAddresses without one.
+func (c *Controller) simIPv6IPAM() error {
+	if len(c.IPv6Labels) == 0 {
+		return nil
+	}
+
+	addresses, err := c.IpamClient.IpamV1().IpAddresses(metav1.NamespaceAll).List(metav1.ListOptions{LabelSelector: labels.SelectorFromSet(c.IPv6Labels).String()})
+	if err != nil {
+		return err
+	}
+
+	used := map[string]bool{}
+	for _, address := range addresses.Items {
+		used[address.Status.Address] = true
+	}
+
+	next := 0x10
+	for _, address := range addresses.Items {
+		if address.Status.Address != "" {
+			continue
+		}
+		for used[fmt.Sprintf("2001:db8::%x", next)] {
+			next++
+		}
+		newaddress := address.DeepCopy()
+		newaddress.Status.Address = fmt.Sprintf("2001:db8::%x", next)
+		used[newaddress.Status.Address] = true
+
+		log.Debugf("[simIPv6IPAM] assigning %s to '%s-%s'", newaddress.Status.Address, address.Namespace, address.Name)
+
+		if _, err := c.IpamClient.IpamV1().IpAddresses(address.Namespace).Update(newaddress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // simulate the behaviour of the controllers we depend on
 func (c *Controller) simulate() error {
 
 	// This isn't what it looks like.
 	time.Sleep(2 * time.Second)
 
-	err := lbutil.SimIPAM(c.IpamClient)
+	err := c.simIPv6IPAM()
+	if err != nil {
+		return err
+	}
+
+	err = lbutil.SimIPAM(c.IpamClient)
 	if err != nil {
 		return err
 	}
@@ -374,3 +425,1094 @@ func TestChangePort(t *testing.T) {
 	a.Nil(err)
 	a.NotNil(cm443)
 }
+
+// Test that the loadbalancing algorithm can be selected per Service and unknown algorithms are rejected
+func TestBalanceAnnotation(t *testing.T) {
+
+	c := testEnvironment()
+	a := assert.New(t)
+
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "myservice",
+			Namespace:   "default",
+			Annotations: map[string]string{AnnNxBalance: "least-connections-member"},
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{
+				{
+					Port:     80,
+					NodePort: 33978,
+				},
+			},
+		},
+	}
+
+	bad := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "badservice",
+			Namespace:   "default",
+			Annotations: map[string]string{AnnNxBalance: "least-connection"},
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{
+				{
+					Port:     80,
+					NodePort: 33979,
+				},
+			},
+		},
+	}
+
+	_, err := c.Kubernetes.CoreV1().Services("default").Create(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	_, err = c.Kubernetes.CoreV1().Services("default").Create(bad)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	cm80, err := c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-myservice-80", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+
+	var vServer F5VirtualServerConfig
+
+	err = json.Unmarshal([]byte(cm80.Data["data"]), &vServer)
+	a.Nil(err)
+	a.Equal("least-connections-member", vServer.VirtualServer.Frontend.Balance)
+
+	_, err = c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-badservice-80", metav1.GetOptions{})
+	a.True(errors.IsNotFound(err))
+}
+
+// Test that health monitors are created from annotations or from readiness probes
+func TestHealthMonitors(t *testing.T) {
+
+	c := testEnvironment()
+	a := assert.New(t)
+
+	_, err := c.Kubernetes.CoreV1().Pods("default").Create(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "mypod",
+			Namespace: "default",
+			Labels:    map[string]string{"app": "probed"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name:  "web",
+					Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}},
+					ReadinessProbe: &corev1.Probe{
+						Handler: corev1.Handler{
+							HTTPGet: &corev1.HTTPGetAction{Path: "/ready", Port: intstr.FromString("http")},
+						},
+						PeriodSeconds: 10,
+					},
+				},
+			},
+		},
+	})
+	if !a.Nil(err) {
+		return
+	}
+
+	probed := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "probed",
+			Namespace:   "default",
+			Annotations: map[string]string{},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeNodePort,
+			Selector: map[string]string{"app": "probed"},
+			Ports: []corev1.ServicePort{
+				{
+					Port:       80,
+					TargetPort: intstr.FromInt(8080),
+					NodePort:   33978,
+				},
+			},
+		},
+	}
+
+	annotated := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "annotated",
+			Namespace: "default",
+			Annotations: map[string]string{
+				AnnNxHealthPath:     "/healthz",
+				AnnNxHealthInterval: "10",
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{
+				{
+					Port:     80,
+					NodePort: 33979,
+				},
+			},
+		},
+	}
+
+	_, err = c.Kubernetes.CoreV1().Services("default").Create(probed)
+	if !a.Nil(err) {
+		return
+	}
+
+	_, err = c.Kubernetes.CoreV1().Services("default").Create(annotated)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	cm, err := c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-probed-80", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+
+	var vServer F5VirtualServerConfig
+
+	err = json.Unmarshal([]byte(cm.Data["data"]), &vServer)
+	a.Nil(err)
+	a.Equal([]F5HealthMonitor{{Protocol: "http", Send: "GET /ready HTTP/1.0\r\n\r\n", Interval: 10, Timeout: 31}}, vServer.VirtualServer.Backend.HealthMonitors)
+
+	cm, err = c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-annotated-80", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+
+	vServer = F5VirtualServerConfig{}
+
+	err = json.Unmarshal([]byte(cm.Data["data"]), &vServer)
+	a.Nil(err)
+	a.Equal([]F5HealthMonitor{{Protocol: "http", Send: "GET /healthz HTTP/1.0\r\n\r\n", Interval: 10, Timeout: 31}}, vServer.VirtualServer.Backend.HealthMonitors)
+}
+
+// Test that a Pod started after its Service updates the health monitor, and that numeric
+// probe ports don't have to be declared by the container
+func TestHealthMonitorFromNewPod(t *testing.T) {
+	c := testEnvironment()
+	a := assert.New(t)
+
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "probed",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeNodePort,
+			Selector: map[string]string{"app": "probed"},
+			Ports:    []corev1.ServicePort{{Port: 80, TargetPort: intstr.FromInt(8080), NodePort: 33978}},
+		},
+	}
+
+	_, err := c.Kubernetes.CoreV1().Services("default").Create(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	_, err = c.Kubernetes.CoreV1().Pods("default").Create(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "mypod",
+			Namespace: "default",
+			Labels:    map[string]string{"app": "probed"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name: "web",
+					ReadinessProbe: &corev1.Probe{
+						Handler: corev1.Handler{
+							HTTPGet: &corev1.HTTPGetAction{Path: "/ready", Port: intstr.FromInt(8080)},
+						},
+						PeriodSeconds: 5,
+					},
+				},
+			},
+		},
+	})
+	if !a.Nil(err) {
+		return
+	}
+
+	time.Sleep(2 * time.Second)
+
+	cm, err := c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-probed-80", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+
+	var vServer F5VirtualServerConfig
+
+	err = json.Unmarshal([]byte(cm.Data["data"]), &vServer)
+	a.Nil(err)
+	a.Equal([]F5HealthMonitor{{Protocol: "http", Send: "GET /ready HTTP/1.0\r\n\r\n", Interval: 5, Timeout: 16}}, vServer.VirtualServer.Backend.HealthMonitors)
+}
+
+// Test that HTTP mode Services with several ports get one configmap per service port
+func TestHTTPPortMap(t *testing.T) {
+
+	c := testEnvironment()
+	a := assert.New(t)
+
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "myservice",
+			Namespace: "default",
+			Annotations: map[string]string{
+				AnnNxVipMode: "http",
+				AnnNxPortMap: "443:8443,80:8080",
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{
+				{
+					Port:     8080,
+					NodePort: 33978,
+				},
+				{
+					Port:     8443,
+					NodePort: 32156,
+				},
+			},
+		},
+	}
+
+	_, err := c.Kubernetes.CoreV1().Services("default").Create(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	for servicePort, frontendPort := range map[int32]int32{8080: 80, 8443: 443} {
+		cm, err := c.Kubernetes.CoreV1().ConfigMaps("default").Get(fmt.Sprintf("bigip-myservice-%d", servicePort), metav1.GetOptions{})
+		if !a.Nil(err) {
+			return
+		}
+
+		var vServer F5VirtualServerConfig
+
+		err = json.Unmarshal([]byte(cm.Data["data"]), &vServer)
+		a.Nil(err)
+		a.Equal(frontendPort, vServer.VirtualServer.Frontend.VirtualAddress.Port)
+		a.Equal(servicePort, vServer.VirtualServer.Backend.ServicePort)
+	}
+
+	s, err = c.Kubernetes.CoreV1().Services("default").Get("myservice", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.NotEmpty(s.Annotations[lbutil.AnnNxVIP])
+}
+
+// Test that Services of type LoadBalancer get loadbalancing and report the VIP in their status
+func TestLoadBalancerService(t *testing.T) {
+
+	c := testEnvironment()
+	a := assert.New(t)
+
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "myservice",
+			Namespace:   "default",
+			Annotations: map[string]string{},
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeLoadBalancer,
+			Ports: []corev1.ServicePort{
+				{
+					Port:     80,
+					NodePort: 33978,
+				},
+			},
+		},
+	}
+
+	_, err := c.Kubernetes.CoreV1().Services("default").Create(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	s, err = c.Kubernetes.CoreV1().Services("default").Get("myservice", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+
+	a.Equal(corev1.ServiceTypeLoadBalancer, s.Spec.Type)
+
+	vip := s.Annotations[lbutil.AnnNxVIP]
+	if !a.NotEmpty(vip) {
+		return
+	}
+
+	a.Equal([]corev1.LoadBalancerIngress{{IP: vip}}, s.Status.LoadBalancer.Ingress)
+
+	// the VIP is removed from the status when the Service is no longer loadbalanced
+
+	s.Spec.Type = corev1.ServiceTypeClusterIP
+	s.Spec.Ports[0].NodePort = 0
+	_, err = c.Kubernetes.CoreV1().Services("default").Update(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	s, err = c.Kubernetes.CoreV1().Services("default").Get("myservice", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.Empty(s.Status.LoadBalancer.Ingress)
+}
+
+// Test that the state of a Service is tracked for the metrics
+func TestServiceStateMetrics(t *testing.T) {
+
+	c := testEnvironment()
+	a := assert.New(t)
+
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "myservice",
+			Namespace:   "default",
+			Annotations: map[string]string{},
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{
+				{
+					Port:     80,
+					NodePort: 33978,
+				},
+			},
+		},
+	}
+
+	_, err := c.Kubernetes.CoreV1().Services("default").Create(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	time.Sleep(2 * time.Second)
+
+	c.serviceStates.Lock()
+	a.Equal(serviceWaitingForIPAM, c.serviceStates.states["default/myservice"])
+	c.serviceStates.Unlock()
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	c.serviceStates.Lock()
+	a.Equal(serviceReady, c.serviceStates.states["default/myservice"])
+	c.serviceStates.Unlock()
+
+	err = c.Kubernetes.CoreV1().Services("default").Delete("myservice", &metav1.DeleteOptions{})
+	if !a.Nil(err) {
+		return
+	}
+
+	time.Sleep(2 * time.Second)
+
+	c.serviceStates.Lock()
+	_, found := c.serviceStates.states["default/myservice"]
+	a.False(found)
+	c.serviceStates.Unlock()
+}
+
+// Test that ConfigMaps are labelled with the controller tag and that ConfigMaps
+// of other controller instances are left alone
+func TestControllerTag(t *testing.T) {
+
+	c := testEnvironment()
+	a := assert.New(t)
+
+	other := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "bigip-otherservice-80",
+			Namespace: "default",
+			Labels: map[string]string{
+				"f5type":           "virtual-server",
+				LabelControllerTag: "other",
+			},
+		},
+	}
+
+	_, err := c.Kubernetes.CoreV1().ConfigMaps("default").Create(other)
+	if !a.Nil(err) {
+		return
+	}
+
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "myservice",
+			Namespace:   "default",
+			Annotations: map[string]string{},
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{
+				{
+					Port:     80,
+					NodePort: 33978,
+				},
+			},
+		},
+	}
+
+	_, err = c.Kubernetes.CoreV1().Services("default").Create(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	cm, err := c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-myservice-80", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.Equal("kubernetes", cm.Labels[LabelControllerTag])
+
+	_, err = c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-otherservice-80", metav1.GetOptions{})
+	a.Nil(err)
+}
+
+// Test that obsolete ConfigMaps are removed for Services with dashes in their names,
+// including ConfigMaps created by older versions without labels
+func TestRemovePortDashedName(t *testing.T) {
+
+	c := testEnvironment()
+	a := assert.New(t)
+
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "my-api",
+			Namespace:   "default",
+			UID:         "6b5d1fd3-7d0a-4a8e-9b61-2b3b4a1f8d5e",
+			Annotations: map[string]string{},
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{
+				{
+					Port:     80,
+					NodePort: 33978,
+				},
+				{
+					Port:     443,
+					NodePort: 32156,
+				},
+			},
+		},
+	}
+
+	s, err := c.Kubernetes.CoreV1().Services("default").Create(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	cm80, err := c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-my-api-80", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.Equal("my-api", cm80.Labels[LabelServiceName])
+	a.Equal("80", cm80.Labels[LabelServicePort])
+
+	legacy := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "bigip-my-api-8080",
+			Namespace: "default",
+			Labels:    map[string]string{"f5type": "virtual-server"},
+			OwnerReferences: []metav1.OwnerReference{{
+				Kind:       "Service",
+				APIVersion: "v1",
+				Name:       s.Name,
+				UID:        s.UID,
+			}},
+		},
+	}
+
+	_, err = c.Kubernetes.CoreV1().ConfigMaps("default").Create(legacy)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.BackfillConfigMapLabels(); !a.Nil(err) {
+		return
+	}
+
+	// Remove port 80
+
+	s.Spec.Ports = []corev1.ServicePort{
+		{
+			Port:     443,
+			NodePort: 32156,
+		},
+	}
+
+	s, err = c.Kubernetes.CoreV1().Services("default").Update(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	_, err = c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-my-api-80", metav1.GetOptions{})
+	a.True(errors.IsNotFound(err))
+
+	_, err = c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-my-api-8080", metav1.GetOptions{})
+	a.True(errors.IsNotFound(err))
+
+	_, err = c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-my-api-443", metav1.GetOptions{})
+	a.Nil(err)
+}
+
+// Test that the labels are only added to ConfigMaps of Services managed by this controller
+func TestBackfillForeignConfigMaps(t *testing.T) {
+
+	c := testEnvironment()
+	c.RequireTag = true
+	a := assert.New(t)
+
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "other-api",
+			Namespace: "default",
+			UID:       "other-api-uid",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{{Port: 80, NodePort: 33978}},
+		},
+	}
+
+	s, err := c.Kubernetes.CoreV1().Services("default").Create(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	for _, cm := range []*corev1.ConfigMap{
+		// owned by a Service without nexinto.com/req-vip
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "bigip-other-api-80",
+				Namespace: "default",
+				Labels:    map[string]string{"f5type": "virtual-server"},
+				OwnerReferences: []metav1.OwnerReference{{
+					Kind:       "Service",
+					APIVersion: "v1",
+					Name:       s.Name,
+					UID:        s.UID,
+				}},
+			},
+		},
+		// created by hand
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "bigip-manual-80",
+				Namespace: "default",
+				Labels:    map[string]string{"f5type": "virtual-server"},
+			},
+		},
+	} {
+		if _, err := c.Kubernetes.CoreV1().ConfigMaps("default").Create(cm); !a.Nil(err) {
+			return
+		}
+	}
+
+	if err := c.BackfillConfigMapLabels(); !a.Nil(err) {
+		return
+	}
+
+	for _, name := range []string{"bigip-other-api-80", "bigip-manual-80"} {
+		cm, err := c.Kubernetes.CoreV1().ConfigMaps("default").Get(name, metav1.GetOptions{})
+		if !a.Nil(err) {
+			return
+		}
+		a.Empty(cm.Labels[LabelControllerTag], name)
+		a.Empty(cm.Labels[LabelServiceName], name)
+	}
+}
+
+// Test that a ConfigMap deleted out of band is recreated
+func TestRestoreDeletedConfigMap(t *testing.T) {
+
+	c := testEnvironment()
+	a := assert.New(t)
+
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "myservice",
+			Namespace:   "default",
+			Annotations: map[string]string{},
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{
+				{
+					Port:     80,
+					NodePort: 33978,
+				},
+				{
+					Port:     443,
+					NodePort: 32156,
+				},
+			},
+		},
+	}
+
+	_, err := c.Kubernetes.CoreV1().Services("default").Create(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	err = c.Kubernetes.CoreV1().ConfigMaps("default").Delete("bigip-myservice-443", &metav1.DeleteOptions{})
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	_, err = c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-myservice-443", metav1.GetOptions{})
+	a.Nil(err)
+
+	events, err := c.Kubernetes.CoreV1().Events("default").List(metav1.ListOptions{})
+	if !a.Nil(err) {
+		return
+	}
+
+	restored := false
+	for _, e := range events.Items {
+		if strings.HasPrefix(e.Message, "Restored deleted loadbalancing configuration for port 443") {
+			restored = true
+		}
+	}
+	a.True(restored)
+}
+
+// Test a Service with TCP and UDP on the same port
+func TestUDPService(t *testing.T) {
+	c := testEnvironment()
+	a := assert.New(t)
+
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "dns",
+			Namespace: "default",
+			Annotations: map[string]string{
+				AnnNxVipMode:        "http",
+				AnnNxSSLProfiles:    "Common/mysite",
+				AnnNxHealthProtocol: "tcp",
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{
+				{Name: "dns-tcp", Protocol: corev1.ProtocolTCP, Port: 53, NodePort: 32053},
+				{Name: "dns-udp", Protocol: corev1.ProtocolUDP, Port: 53, NodePort: 32053},
+			},
+		},
+	}
+
+	_, err := c.Kubernetes.CoreV1().Services("default").Create(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	tcp, err := c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-dns-53", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	var vServer F5VirtualServerConfig
+	if !a.Nil(json.Unmarshal([]byte(tcp.Data["data"]), &vServer)) {
+		return
+	}
+	a.Equal(F5ModeHTTP, vServer.VirtualServer.Frontend.Mode)
+	a.Equal(int32(443), vServer.VirtualServer.Frontend.VirtualAddress.Port)
+	a.NotNil(vServer.VirtualServer.Frontend.SSLProfile)
+	a.Len(vServer.VirtualServer.Backend.HealthMonitors, 1)
+
+	udp, err := c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-dns-53-udp", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.Equal("UDP", udp.Labels[LabelServiceProtocol])
+	vServer = F5VirtualServerConfig{}
+	if !a.Nil(json.Unmarshal([]byte(udp.Data["data"]), &vServer)) {
+		return
+	}
+	a.Equal(F5ModeUDP, vServer.VirtualServer.Frontend.Mode)
+	a.Equal(int32(53), vServer.VirtualServer.Frontend.VirtualAddress.Port)
+	a.Equal(int32(53), vServer.VirtualServer.Backend.ServicePort)
+	a.Nil(vServer.VirtualServer.Frontend.SSLProfile)
+	a.Empty(vServer.VirtualServer.Backend.HealthMonitors)
+
+	s, err = c.Kubernetes.CoreV1().Services("default").Get("dns", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.NotEmpty(s.Annotations[lbutil.AnnNxVIP])
+
+	// removing the UDP port removes only its ConfigMap
+	s.Spec.Ports = s.Spec.Ports[:1]
+	_, err = c.Kubernetes.CoreV1().Services("default").Update(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	_, err = c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-dns-53-udp", metav1.GetOptions{})
+	a.True(errors.IsNotFound(err))
+	_, err = c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-dns-53", metav1.GetOptions{})
+	a.Nil(err)
+}
+
+// Test the partition annotations on Services and Namespaces
+func TestPartitionAnnotation(t *testing.T) {
+
+	c := testEnvironment()
+	a := assert.New(t)
+
+	c.AllowedPartitions = map[string]bool{"tenant1": true}
+
+	ns, err := c.Kubernetes.CoreV1().Namespaces().Get("default", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	ns.Annotations = map[string]string{AnnNxPartition: "tenant1"}
+	_, err = c.Kubernetes.CoreV1().Namespaces().Update(ns)
+	if !a.Nil(err) {
+		return
+	}
+
+	for name, partition := range map[string]string{"service1": "", "service2": "tenant2"} {
+		s := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "default",
+				Annotations: map[string]string{},
+			},
+			Spec: corev1.ServiceSpec{
+				Type: corev1.ServiceTypeNodePort,
+				Ports: []corev1.ServicePort{
+					{
+						Port:     80,
+						NodePort: 33978,
+					},
+				},
+			},
+		}
+		if partition != "" {
+			s.Annotations[AnnNxPartition] = partition
+		}
+
+		_, err := c.Kubernetes.CoreV1().Services("default").Create(s)
+		if !a.Nil(err) {
+			return
+		}
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	cm, err := c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-service1-80", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+
+	f5 := &F5VirtualServerConfig{}
+	if err := json.Unmarshal([]byte(cm.Data["data"]), f5); !a.Nil(err) {
+		return
+	}
+	a.Equal("tenant1", f5.VirtualServer.Frontend.Partition)
+
+	_, err = c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-service2-80", metav1.GetOptions{})
+	a.True(errors.IsNotFound(err))
+}
+
+// Test that a Service cannot use a partition that is only allowed for another Namespace
+func TestPartitionOfOtherNamespace(t *testing.T) {
+
+	c := testEnvironment()
+	a := assert.New(t)
+
+	c.AllowedPartitions = map[string]bool{"tenant1": true, "tenant2": true}
+
+	for name, allowed := range map[string]string{"team1": "tenant1", "team2": "tenant2"} {
+		_, err := c.Kubernetes.CoreV1().Namespaces().Create(&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Annotations: map[string]string{AnnNxAllowedPartitions: allowed},
+			},
+		})
+		if !a.Nil(err) {
+			return
+		}
+	}
+
+	for _, namespace := range []string{"team1", "team2"} {
+		s := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "myservice",
+				Namespace:   namespace,
+				Annotations: map[string]string{AnnNxPartition: "tenant2"},
+			},
+			Spec: corev1.ServiceSpec{
+				Type: corev1.ServiceTypeNodePort,
+				Ports: []corev1.ServicePort{
+					{
+						Port:     80,
+						NodePort: 33978,
+					},
+				},
+			},
+		}
+
+		_, err := c.Kubernetes.CoreV1().Services(namespace).Create(s)
+		if !a.Nil(err) {
+			return
+		}
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	_, err := c.Kubernetes.CoreV1().ConfigMaps("team1").Get("bigip-myservice-80", metav1.GetOptions{})
+	a.True(errors.IsNotFound(err))
+
+	cm, err := c.Kubernetes.CoreV1().ConfigMaps("team2").Get("bigip-myservice-80", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+
+	f5 := &F5VirtualServerConfig{}
+	if err := json.Unmarshal([]byte(cm.Data["data"]), f5); !a.Nil(err) {
+		return
+	}
+	a.Equal("tenant2", f5.VirtualServer.Frontend.Partition)
+}
+
+// Test that annotations on the Namespace are used as defaults for its Services
+func TestNamespaceDefaults(t *testing.T) {
+
+	c := testEnvironment()
+	a := assert.New(t)
+
+	for name, balance := range map[string]string{"service1": "", "service2": "ratio-member"} {
+		s := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "default",
+				Annotations: map[string]string{},
+			},
+			Spec: corev1.ServiceSpec{
+				Type: corev1.ServiceTypeNodePort,
+				Ports: []corev1.ServicePort{
+					{
+						Port:     80,
+						NodePort: 33978,
+					},
+				},
+			},
+		}
+		if balance != "" {
+			s.Annotations[AnnNxBalance] = balance
+		}
+
+		_, err := c.Kubernetes.CoreV1().Services("default").Create(s)
+		if !a.Nil(err) {
+			return
+		}
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	// changing the defaults updates the existing Services
+
+	ns, err := c.Kubernetes.CoreV1().Namespaces().Get("default", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	ns.Annotations = map[string]string{AnnNxBalance: "least-connections-member"}
+	_, err = c.Kubernetes.CoreV1().Namespaces().Update(ns)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	for name, balance := range map[string]string{"service1": "least-connections-member", "service2": "ratio-member"} {
+		cm, err := c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-"+name+"-80", metav1.GetOptions{})
+		if !a.Nil(err) {
+			return
+		}
+
+		f5 := &F5VirtualServerConfig{}
+		if err := json.Unmarshal([]byte(cm.Data["data"]), f5); !a.Nil(err) {
+			return
+		}
+		a.Equal(balance, f5.VirtualServer.Frontend.Balance, name)
+	}
+}
+
+// Test that an empty annotation on the Service overrides the default of the Namespace
+func TestClearNamespaceDefault(t *testing.T) {
+
+	c := testEnvironment()
+	a := assert.New(t)
+
+	ns, err := c.Kubernetes.CoreV1().Namespaces().Get("default", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	ns.Annotations = map[string]string{AnnNxSSLProfiles: "Common/clientssl"}
+	_, err = c.Kubernetes.CoreV1().Namespaces().Update(ns)
+	if !a.Nil(err) {
+		return
+	}
+
+	for name, annotations := range map[string]map[string]string{
+		"service1": {},
+		"service2": {AnnNxSSLProfiles: ""},
+	} {
+		s := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "default",
+				Annotations: annotations,
+			},
+			Spec: corev1.ServiceSpec{
+				Type: corev1.ServiceTypeNodePort,
+				Ports: []corev1.ServicePort{
+					{
+						Port:     443,
+						NodePort: 33443,
+					},
+				},
+			},
+		}
+
+		_, err := c.Kubernetes.CoreV1().Services("default").Create(s)
+		if !a.Nil(err) {
+			return
+		}
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	for name, hasProfile := range map[string]bool{"service1": true, "service2": false} {
+		cm, err := c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-"+name+"-443", metav1.GetOptions{})
+		if !a.Nil(err) {
+			return
+		}
+
+		f5 := &F5VirtualServerConfig{}
+		if err := json.Unmarshal([]byte(cm.Data["data"]), f5); !a.Nil(err) {
+			return
+		}
+		a.Equal(hasProfile, f5.VirtualServer.Frontend.SSLProfile != nil, name)
+	}
+}
+
+// Test that an unknown mode in the defaults of the Namespace makes the Service invalid
+func TestInvalidNamespaceDefault(t *testing.T) {
+
+	c := testEnvironment()
+	a := assert.New(t)
+
+	ns, err := c.Kubernetes.CoreV1().Namespaces().Get("default", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	ns.Annotations = map[string]string{AnnNxVipMode: "htpp"}
+	_, err = c.Kubernetes.CoreV1().Namespaces().Update(ns)
+	if !a.Nil(err) {
+		return
+	}
+
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "myservice",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{{Port: 80, NodePort: 33978}},
+		},
+	}
+
+	_, err = c.Kubernetes.CoreV1().Services("default").Create(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	s, err = c.Kubernetes.CoreV1().Services("default").Get("myservice", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.Equal(VIPStateInvalid, s.Annotations[AnnNxVIPState])
+
+	_, err = c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-myservice-80", metav1.GetOptions{})
+	a.True(errors.IsNotFound(err))
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+type LeaderElectionConfig struct {
+	Namespace string
+	Name      string
+	Identity  string
+
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// Read the leader election configuration from the environment.
+// Returns nil if leader election is not enabled.
+func leaderElectionConfigFromEnv(tag string) (*LeaderElectionConfig, error) {
+	if os.Getenv("LEADER_ELECTION") == "" {
+		return nil, nil
+	}
+
+	le := &LeaderElectionConfig{
+		Namespace:     "kube-system",
+		Name:          "k8s-bigip-ipam-" + tag,
+		LeaseDuration: 15 * time.Second,
+		RenewDeadline: 10 * time.Second,
+		RetryPeriod:   2 * time.Second,
+	}
+
+	if e := os.Getenv("LEADER_ELECTION_NAMESPACE"); e != "" {
+		le.Namespace = e
+	} else if e := os.Getenv("POD_NAMESPACE"); e != "" {
+		le.Namespace = e
+	}
+
+	if e := os.Getenv("LEADER_ELECTION_NAME"); e != "" {
+		le.Name = e
+	}
+
+	if e := os.Getenv("POD_NAME"); e != "" {
+		le.Identity = e
+	} else {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("cannot determine identity for leader election: %s", err.Error())
+		}
+		le.Identity = hostname
+	}
+
+	for name, d := range map[string]*time.Duration{
+		"LEADER_ELECTION_LEASE_DURATION": &le.LeaseDuration,
+		"LEADER_ELECTION_RENEW_DEADLINE": &le.RenewDeadline,
+		"LEADER_ELECTION_RETRY_PERIOD":   &le.RetryPeriod,
+	} {
+		if e := os.Getenv(name); e != "" {
+			v, err := time.ParseDuration(e)
+			if err != nil {
+				return nil, fmt.Errorf("invalid duration '%s' for %s: %s", e, name, err.Error())
+			}
+			*d = v
+		}
+	}
+
+	if le.LeaseDuration <= le.RenewDeadline {
+		return nil, fmt.Errorf("LEADER_ELECTION_LEASE_DURATION must be greater than LEADER_ELECTION_RENEW_DEADLINE")
+	}
+
+	return le, nil
+}
+
+// Like Start(), but the workers only run while this instance holds the leader lease.
+// All instances keep their caches in sync, so a new leader can take over quickly.
+// Returns when the lease is lost or the process is asked to terminate.
+func (c *Controller) StartWithLeaderElection(le *LeaderElectionConfig) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go c.KubernetesFactory.Start(stopCh)
+	go c.ConfigMapFactory.Start(stopCh)
+	go c.IpamFactory.Start(stopCh)
+	go c.BigipFactory.Start(stopCh)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		sigterm := make(chan os.Signal, 1)
+		signal.Notify(sigterm, syscall.SIGTERM)
+		signal.Notify(sigterm, syscall.SIGINT)
+		<-sigterm
+		log.Infof("terminating, releasing leader lease")
+		cancel()
+	}()
+
+	// A standby instance reports ready once its caches are synced, otherwise
+	// a rolling update would wait for the new instances forever.
+	if !c.waitForCaches(stopCh) {
+		log.Errorf("timed out waiting for caches to sync")
+		return
+	}
+	c.health.setStandby()
+
+	log.Infof("waiting for leader lease '%s-%s' as '%s'", le.Namespace, le.Name, le.Identity)
+
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta: metav1.ObjectMeta{
+				Namespace: le.Namespace,
+				Name:      le.Name,
+			},
+			Client: c.Kubernetes.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{
+				Identity: le.Identity,
+			},
+		},
+		LeaseDuration:   le.LeaseDuration,
+		RenewDeadline:   le.RenewDeadline,
+		RetryPeriod:     le.RetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.Infof("acquired leader lease, starting workers")
+				if err := c.BackfillConfigMapLabels(); err != nil {
+					log.Warnf("error adding labels to existing configmaps: %s", err.Error())
+				}
+				go c.RunRoutes(ctx.Done())
+				go c.RunHooks(ctx.Done())
+				c.Run(ctx.Done())
+			},
+			OnStoppedLeading: func() {
+				log.Infof("lost leader lease, workers stopped")
+			},
+			OnNewLeader: func(identity string) {
+				if identity != le.Identity {
+					log.Infof("'%s' is the leader", identity)
+				}
+			},
+		},
+	})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	bigipfake "github.com/Nexinto/k8s-bigip-ipam/pkg/client/clientset/versioned/fake"
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+	ipamfake "github.com/Nexinto/k8s-ipam/pkg/client/clientset/versioned/fake"
+	"github.com/stretchr/testify/assert"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"os"
+	"testing"
+	"time"
+)
+
+// Test the leader election configuration
+func TestLeaderElectionConfig(t *testing.T) {
+	a := assert.New(t)
+
+	for _, e := range []string{"LEADER_ELECTION", "LEADER_ELECTION_NAMESPACE", "LEADER_ELECTION_NAME", "POD_NAME", "POD_NAMESPACE", "LEADER_ELECTION_LEASE_DURATION", "LEADER_ELECTION_RENEW_DEADLINE", "LEADER_ELECTION_RETRY_PERIOD"} {
+		defer os.Setenv(e, os.Getenv(e))
+		os.Unsetenv(e)
+	}
+
+	le, err := leaderElectionConfigFromEnv("kubernetes")
+	a.Nil(err)
+	a.Nil(le)
+
+	os.Setenv("LEADER_ELECTION", "true")
+	os.Setenv("POD_NAME", "k8s-bigip-ipam-1")
+	os.Setenv("POD_NAMESPACE", "lb")
+	os.Setenv("LEADER_ELECTION_LEASE_DURATION", "30s")
+
+	le, err = leaderElectionConfigFromEnv("kubernetes")
+	if !a.Nil(err) {
+		return
+	}
+
+	a.Equal("lb", le.Namespace)
+	a.Equal("k8s-bigip-ipam-kubernetes", le.Name)
+	a.Equal("k8s-bigip-ipam-1", le.Identity)
+	a.Equal(30*time.Second, le.LeaseDuration)
+	a.Equal(10*time.Second, le.RenewDeadline)
+
+	os.Setenv("LEADER_ELECTION_RENEW_DEADLINE", "1m")
+
+	_, err = leaderElectionConfigFromEnv("kubernetes")
+	a.NotNil(err)
+}
+
+// Create a test environment with synced caches, but without workers, like an instance
+// that waits for the leader lease.
+func testStandbyEnvironment() (*Controller, chan struct{}) {
+	c := &Controller{
+		Kubernetes:  fake.NewSimpleClientset(),
+		IpamClient:  ipamfake.NewSimpleClientset(),
+		BigipClient: bigipfake.NewSimpleClientset(),
+		Tag:         "kubernetes",
+	}
+
+	c.Initialize()
+	c.InitializeHooks()
+
+	stopCh := make(chan struct{})
+	go c.KubernetesFactory.Start(stopCh)
+	go c.ConfigMapFactory.Start(stopCh)
+	go c.IpamFactory.Start(stopCh)
+	go c.BigipFactory.Start(stopCh)
+
+	if !c.waitForCaches(stopCh) {
+		panic("Timed out waiting for caches to sync")
+	}
+
+	return c, stopCh
+}
+
+// Test that deletions are only processed by the leader
+func TestStandbyDeletion(t *testing.T) {
+	c, stopCh := testStandbyEnvironment()
+	defer close(stopCh)
+	a := assert.New(t)
+
+	i := &extensionsv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "myingress",
+			Namespace:   "default",
+			UID:         "1234",
+			Annotations: map[string]string{AnnIngressClass: IngressClassF5},
+		},
+	}
+	if _, err := c.Kubernetes.ExtensionsV1beta1().Ingresses("default").Create(i); !a.Nil(err) {
+		return
+	}
+
+	_, err := c.IpamClient.IpamV1().IpAddresses("default").Create(&ipamv1.IpAddress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ipAddressNameForIngress(i),
+			Namespace: "default",
+			OwnerReferences: []metav1.OwnerReference{{
+				Kind:       "Ingress",
+				APIVersion: "extensions/v1beta1",
+				Name:       i.Name,
+				UID:        i.UID,
+			}},
+		},
+	})
+	if !a.Nil(err) {
+		return
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	ipam := c.IpamClient.(*ipamfake.Clientset)
+	ipam.ClearActions()
+
+	if err := c.Kubernetes.ExtensionsV1beta1().Ingresses("default").Delete("myingress", &metav1.DeleteOptions{}); !a.Nil(err) {
+		return
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	a.Equal(1, c.DeletionQueue.Len())
+	for _, action := range ipam.Actions() {
+		a.Contains([]string{"get", "list", "watch"}, action.GetVerb())
+	}
+
+	// the new leader releases the address
+	go c.RunHooks(stopCh)
+
+	time.Sleep(100 * time.Millisecond)
+
+	_, err = c.IpamClient.IpamV1().IpAddresses("default").Get(ipAddressNameForIngress(i), metav1.GetOptions{})
+	a.True(errors.IsNotFound(err))
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+const metricsNamespace = "k8s_bigip_ipam"
+
+var (
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "workqueue",
+		Name:      "depth",
+		Help:      "Current depth of the workqueue.",
+	}, []string{"queue"})
+
+	queueAdds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "workqueue",
+		Name:      "adds_total",
+		Help:      "Number of items added to the workqueue.",
+	}, []string{"queue"})
+
+	queueLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "workqueue",
+		Name:      "queue_duration_seconds",
+		Help:      "How long an item stays in the workqueue before it is processed.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"queue"})
+
+	queueWorkDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "workqueue",
+		Name:      "work_duration_seconds",
+		Help:      "How long processing an item from the workqueue takes.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"queue"})
+
+	queueUnfinishedWork = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "workqueue",
+		Name:      "unfinished_work_seconds",
+		Help:      "How long the items currently in processing have been processed in total.",
+	}, []string{"queue"})
+
+	queueLongestRunning = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "workqueue",
+		Name:      "longest_running_processor_seconds",
+		Help:      "How long the longest running item has been processed.",
+	}, []string{"queue"})
+
+	queueRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "workqueue",
+		Name:      "retries_total",
+		Help:      "Number of items requeued after an error.",
+	}, []string{"queue"})
+
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "How long processing a resource takes.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"kind"})
+
+	reconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_errors_total",
+		Help:      "Number of failed attempts to process a resource.",
+	}, []string{"kind"})
+
+	servicesByState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "services",
+		Help:      "Number of loadbalanced Services by state (waiting_ipam, waiting_bigip, bigip_timeout, ready).",
+	}, []string{"state"})
+
+	serviceVIPReady = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "service_vip_ready_seconds",
+		Help:      "Time from the creation of a Service until its VIP is ready.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		queueDepth,
+		queueAdds,
+		queueLatency,
+		queueWorkDuration,
+		queueUnfinishedWork,
+		queueLongestRunning,
+		queueRetries,
+		reconcileDuration,
+		reconcileErrors,
+		servicesByState,
+		serviceVIPReady,
+	)
+}
+
+// Record the duration and the result of processing a resource.
+func observeReconcile(kind string, start time.Time, err error) {
+	reconcileDuration.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+	if err != nil {
+		reconcileErrors.WithLabelValues(kind).Inc()
+	}
+}
+
+// Provides the metrics for the named workqueues; set with workqueue.SetProvider
+// before the queues are created.
+type queueMetricsProvider struct {
+	health *healthState
+}
+
+func (queueMetricsProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return queueDepth.WithLabelValues(name)
+}
+
+func (queueMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	return queueAdds.WithLabelValues(name)
+}
+
+func (queueMetricsProvider) NewLatencyMetric(name string) workqueue.HistogramMetric {
+	return queueLatency.WithLabelValues(name)
+}
+
+func (queueMetricsProvider) NewWorkDurationMetric(name string) workqueue.HistogramMetric {
+	return queueWorkDuration.WithLabelValues(name)
+}
+
+func (queueMetricsProvider) NewUnfinishedWorkSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return queueUnfinishedWork.WithLabelValues(name)
+}
+
+func (p queueMetricsProvider) NewLongestRunningProcessorSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	if p.health == nil {
+		return queueLongestRunning.WithLabelValues(name)
+	}
+	return &longestRunningGauge{Gauge: queueLongestRunning.WithLabelValues(name), queue: name, health: p.health}
+}
+
+func (queueMetricsProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return queueRetries.WithLabelValues(name)
+}
+
+// The deprecated metrics are not exported.
+
+func (queueMetricsProvider) NewDeprecatedDepthMetric(name string) workqueue.GaugeMetric {
+	return noopMetric{}
+}
+
+func (queueMetricsProvider) NewDeprecatedAddsMetric(name string) workqueue.CounterMetric {
+	return noopMetric{}
+}
+
+func (queueMetricsProvider) NewDeprecatedLatencyMetric(name string) workqueue.SummaryMetric {
+	return noopMetric{}
+}
+
+func (queueMetricsProvider) NewDeprecatedWorkDurationMetric(name string) workqueue.SummaryMetric {
+	return noopMetric{}
+}
+
+func (queueMetricsProvider) NewDeprecatedUnfinishedWorkSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return noopMetric{}
+}
+
+func (queueMetricsProvider) NewDeprecatedLongestRunningProcessorMicrosecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return noopMetric{}
+}
+
+func (queueMetricsProvider) NewDeprecatedRetriesMetric(name string) workqueue.CounterMetric {
+	return noopMetric{}
+}
+
+type noopMetric struct{}
+
+func (noopMetric) Inc()            {}
+func (noopMetric) Dec()            {}
+func (noopMetric) Set(float64)     {}
+func (noopMetric) Observe(float64) {}
+
+type serviceState string
+
+const (
+	serviceWaitingForIPAM  serviceState = "waiting_ipam"
+	serviceWaitingForBigIP serviceState = "waiting_bigip"
+	serviceBigIPTimeout    serviceState = "bigip_timeout"
+	serviceReady           serviceState = "ready"
+)
+
+// Keeps track of the state of all loadbalanced Services for the servicesByState metric.
+// The zero value is ready to use.
+type serviceStateTracker struct {
+	sync.Mutex
+	states map[string]serviceState
+}
+
+func (t *serviceStateTracker) set(key string, state serviceState) {
+	t.Lock()
+	defer t.Unlock()
+	if t.states == nil {
+		t.states = map[string]serviceState{}
+	}
+	t.states[key] = state
+	t.update()
+}
+
+func (t *serviceStateTracker) remove(key string) {
+	t.Lock()
+	defer t.Unlock()
+	delete(t.states, key)
+	t.update()
+}
+
+func (t *serviceStateTracker) update() {
+	counts := map[serviceState]int{
+		serviceWaitingForIPAM:  0,
+		serviceWaitingForBigIP: 0,
+		serviceBigIPTimeout:    0,
+		serviceReady:           0,
+	}
+	for _, state := range t.states {
+		counts[state]++
+	}
+	for state, n := range counts {
+		servicesByState.WithLabelValues(string(state)).Set(float64(n))
+	}
+}
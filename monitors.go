@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// BIG-IP defaults for http monitors
+	defaultMonitorInterval = 5
+	defaultMonitorTimeout  = 16
+
+	// Kubernetes defaults for probes
+	defaultProbePeriod           = 10
+	defaultProbeFailureThreshold = 3
+)
+
+// Monitor protocols supported by k8s-bigip-ctlr.
+var F5MonitorProtocols = map[string]bool{
+	"http":  true,
+	"https": true,
+	"tcp":   true,
+}
+
+// Create the health monitor configured by the annotations of a Service.
+// Returns nil if no health monitor annotation is set.
+func healthMonitorFromAnnotations(annotations map[string]string) (*F5HealthMonitor, error) {
+	path := annotations[AnnNxHealthPath]
+	protocol := annotations[AnnNxHealthProtocol]
+	send := annotations[AnnNxHealthSend]
+	interval := annotations[AnnNxHealthInterval]
+	timeout := annotations[AnnNxHealthTimeout]
+
+	if path == "" && protocol == "" && send == "" && interval == "" && timeout == "" {
+		return nil, nil
+	}
+
+	m := &F5HealthMonitor{
+		Protocol: "http",
+		Interval: defaultMonitorInterval,
+		Timeout:  defaultMonitorTimeout,
+	}
+
+	if protocol != "" {
+		if !F5MonitorProtocols[protocol] {
+			return nil, fmt.Errorf("unknown health monitor protocol '%s' in annotation %s", protocol, AnnNxHealthProtocol)
+		}
+		m.Protocol = protocol
+	}
+
+	if send != "" {
+		m.Send = send
+	} else if path != "" {
+		if m.Protocol == "tcp" {
+			return nil, fmt.Errorf("annotation %s requires health monitor protocol http or https", AnnNxHealthPath)
+		}
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("health check path '%s' in annotation %s must start with '/'", path, AnnNxHealthPath)
+		}
+		m.Send = httpSendString(path)
+	} else if m.Protocol != "tcp" {
+		m.Send = httpSendString("/")
+	}
+
+	if interval != "" {
+		i, err := strconv.Atoi(interval)
+		if err != nil || i <= 0 {
+			return nil, fmt.Errorf("invalid health monitor interval '%s' in annotation %s", interval, AnnNxHealthInterval)
+		}
+		m.Interval = int32(i)
+		m.Timeout = 3*m.Interval + 1
+	}
+
+	if timeout != "" {
+		t, err := strconv.Atoi(timeout)
+		if err != nil || t <= 0 {
+			return nil, fmt.Errorf("invalid health monitor timeout '%s' in annotation %s", timeout, AnnNxHealthTimeout)
+		}
+		m.Timeout = int32(t)
+	}
+
+	return m, nil
+}
+
+// Derive health monitors from the HTTP readiness probes of the pods selected by a Service.
+// Ports without a matching readiness probe get no health monitor. Returns false if the pods
+// of the namespace are not listed yet.
+func (c *Controller) healthMonitorsFromProbes(service *corev1.Service) (map[int32]F5HealthMonitor, bool, error) {
+	if len(service.Spec.Selector) == 0 {
+		c.unwatchPods(service)
+		return nil, true, nil
+	}
+
+	lister, synced := c.watchPods(service)
+	if !synced {
+		return nil, false, nil
+	}
+
+	pods, err := lister.List(labels.SelectorFromSet(service.Spec.Selector))
+	if err != nil {
+		return nil, true, err
+	}
+
+	monitors := map[int32]F5HealthMonitor{}
+
+	for _, port := range service.Spec.Ports {
+		if protocolOf(port) == corev1.ProtocolUDP {
+			continue
+		}
+		for _, pod := range pods {
+			if m, ok := probeMonitorFor(pod, port); ok {
+				monitors[port.Port] = m
+				break
+			}
+		}
+	}
+
+	return monitors, true, nil
+}
+
+// Find the readiness probe for the target port of a service port and convert it to a health monitor.
+func probeMonitorFor(pod *corev1.Pod, port corev1.ServicePort) (F5HealthMonitor, bool) {
+	target := port.TargetPort
+	if target.Type == intstr.Int && target.IntVal == 0 {
+		target = intstr.FromInt(int(port.Port))
+	}
+
+	for _, container := range pod.Spec.Containers {
+		targetPort, ok := resolvePort(target, &container)
+		if !ok {
+			continue
+		}
+
+		probe := container.ReadinessProbe
+		if probe == nil || probe.HTTPGet == nil {
+			continue
+		}
+
+		probePort, ok := resolvePort(probe.HTTPGet.Port, &container)
+		if !ok || probePort != targetPort {
+			continue
+		}
+
+		m := F5HealthMonitor{
+			Protocol: strings.ToLower(string(probe.HTTPGet.Scheme)),
+			Interval: probe.PeriodSeconds,
+		}
+
+		if m.Protocol == "" {
+			m.Protocol = "http"
+		}
+
+		path := probe.HTTPGet.Path
+		if path == "" {
+			path = "/"
+		}
+		m.Send = httpSendString(path)
+
+		if m.Interval == 0 {
+			m.Interval = defaultProbePeriod
+		}
+
+		failureThreshold := probe.FailureThreshold
+		if failureThreshold == 0 {
+			failureThreshold = defaultProbeFailureThreshold
+		}
+		m.Timeout = m.Interval*failureThreshold + 1
+
+		return m, true
+	}
+
+	return F5HealthMonitor{}, false
+}
+
+// Resolve a (possibly named) port of a container to its number. Numeric ports
+// don't have to be declared by the container.
+func resolvePort(port intstr.IntOrString, container *corev1.Container) (int32, bool) {
+	if port.Type == intstr.Int {
+		return port.IntVal, port.IntVal != 0
+	}
+
+	for _, p := range container.Ports {
+		if p.Name == port.StrVal {
+			return p.ContainerPort, true
+		}
+	}
+	return 0, false
+}
+
+// Does the Pod have a readiness probe that can become a health monitor?
+func hasHTTPReadinessProbe(pod *corev1.Pod) bool {
+	for _, container := range pod.Spec.Containers {
+		if container.ReadinessProbe != nil && container.ReadinessProbe.HTTPGet != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func httpSendString(path string) string {
+	return fmt.Sprintf("GET %s HTTP/1.0\r\n\r\n", path)
+}
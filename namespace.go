@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Annotations that can be set on a Namespace as the default for all Services in the Namespace.
+var namespaceDefaultAnnotations = []string{
+	AnnNxVipMode,
+	AnnNxSSLProfiles,
+	AnnNxBalance,
+	AnnNxPartition,
+}
+
+// The value of an annotation on the Service, or the default if the Service doesn't have it.
+// An empty annotation on the Service overrides the default, e.g. to use no SSL profiles.
+func annotationWithDefault(service *corev1.Service, defaults map[string]string, ann string) string {
+	if v, ok := service.Annotations[ann]; ok {
+		return v
+	}
+	return defaults[ann]
+}
+
+// The annotations of the Service, with the defaults added for the annotations the Service doesn't have.
+func annotationsWithDefaults(service *corev1.Service, defaults map[string]string) map[string]string {
+	annotations := map[string]string{}
+	for k, v := range defaults {
+		annotations[k] = v
+	}
+	for k, v := range service.Annotations {
+		annotations[k] = v
+	}
+	return annotations
+}
+
+// The Namespace of a Service; an empty Namespace if it cannot be found.
+func (c *Controller) namespaceOf(service *corev1.Service) (*corev1.Namespace, error) {
+	namespace, err := c.NamespaceLister.Get(service.Namespace)
+	if errors.IsNotFound(err) {
+		return &corev1.Namespace{}, nil
+	}
+	return namespace, err
+}
+
+// Process all Services in the Namespace if its defaults have changed.
+func (c *Controller) NamespaceCreatedOrUpdated(namespace *corev1.Namespace) (err error) {
+	log.Debugf("processing namespace '%s'", namespace.Name)
+	defer requeueOnError(c.NamespaceQueue, namespace, &err)
+
+	defaults := map[string]string{}
+	for _, ann := range namespaceDefaultAnnotations {
+		if v, ok := namespace.Annotations[ann]; ok {
+			defaults[ann] = v
+		}
+	}
+	if v, ok := namespace.Annotations[AnnNxAllowedPartitions]; ok {
+		defaults[AnnNxAllowedPartitions] = v
+	}
+
+	if !c.namespaceDefaults.changed(namespace.Name, defaults) {
+		return nil
+	}
+
+	services, err := c.ServiceLister.Services(namespace.Name).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	log.Infof("defaults for namespace '%s' changed, processing %d service(s)", namespace.Name, len(services))
+
+	for _, service := range services {
+		c.ServiceQueue.Add(service.Namespace + "/" + service.Name)
+	}
+
+	return nil
+}
+
+// Remembers the defaults of every Namespace. The zero value is ready to use.
+type namespaceDefaultsTracker struct {
+	sync.Mutex
+	defaults map[string]map[string]string
+}
+
+// Record the defaults of a Namespace; returns true if they are different from the last ones.
+func (t *namespaceDefaultsTracker) changed(namespace string, defaults map[string]string) bool {
+	t.Lock()
+	defer t.Unlock()
+	if t.defaults == nil {
+		t.defaults = map[string]map[string]string{}
+	}
+	old, seen := t.defaults[namespace]
+	t.defaults[namespace] = defaults
+	if !seen {
+		return len(defaults) > 0
+	}
+	return !reflect.DeepEqual(old, defaults)
+}
@@ -0,0 +1,5 @@
+package bigip
+
+const (
+	GroupName = "bigip.nexinto.com"
+)
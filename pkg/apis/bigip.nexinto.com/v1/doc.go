@@ -0,0 +1,5 @@
+// +k8s:deepcopy-gen=package
+// +groupName=bigip.nexinto.com
+
+// Package v1 is the v1 version of the API.
+package v1
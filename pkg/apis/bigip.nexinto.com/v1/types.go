@@ -0,0 +1,58 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// VirtualServerPolicy holds the loadbalancing settings for the Services that reference it
+// with the annotation nexinto.com/vip-policy.
+type VirtualServerPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec VirtualServerPolicySpec `json:"spec"`
+}
+
+type VirtualServerPolicySpec struct {
+	// http or tcp
+	Mode string `json:"mode,omitempty"`
+
+	// one of the loadbalancing algorithms supported by the BIG-IP
+	Balance string `json:"balance,omitempty"`
+
+	// SSL profiles for SSL termination, for example Common/mysite
+	SSLProfiles []string `json:"sslProfiles,omitempty"`
+
+	Monitor *HealthMonitor `json:"monitor,omitempty"`
+
+	// BIG-IP partition; must be allowed in the controller configuration
+	Partition string `json:"partition,omitempty"`
+}
+
+type HealthMonitor struct {
+	// http, https or tcp
+	Protocol string `json:"protocol,omitempty"`
+
+	// path for http and https monitors
+	Path string `json:"path,omitempty"`
+
+	// send string, overrides path
+	Send string `json:"send,omitempty"`
+
+	// seconds
+	Interval int32 `json:"interval,omitempty"`
+	Timeout  int32 `json:"timeout,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// VirtualServerPolicyList is a list of VirtualServerPolicy resources
+type VirtualServerPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []VirtualServerPolicy `json:"items"`
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kubernetesinformers "k8s.io/client-go/informers"
+	corelisterv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Pods are only needed for the Services that take their health monitors from readiness probes.
+// Watching every Pod in the cluster would keep all of them in memory, so the Pods of a namespace
+// are only watched while it has such a Service. The zero value is ready to use.
+type podWatcher struct {
+	sync.Mutex
+	namespaces map[string]*namespacePods
+}
+
+type namespacePods struct {
+	lister corelisterv1.PodLister
+	synced cache.InformerSynced
+	stop   chan struct{}
+
+	// the selectors of the Services that use the readiness probes, by Service name
+	services map[string]labels.Selector
+}
+
+// Watch the Pods selected by a Service. Returns false if they are not listed yet; the Service is
+// processed again when they are.
+func (c *Controller) watchPods(service *corev1.Service) (corelisterv1.PodNamespaceLister, bool) {
+	c.pods.Lock()
+	defer c.pods.Unlock()
+
+	if c.pods.namespaces == nil {
+		c.pods.namespaces = map[string]*namespacePods{}
+	}
+
+	ns, ok := c.pods.namespaces[service.Namespace]
+	if !ok {
+		ns = c.startPodInformer(service.Namespace)
+		c.pods.namespaces[service.Namespace] = ns
+	}
+	ns.services[service.Name] = labels.SelectorFromSet(service.Spec.Selector)
+
+	return ns.lister.Pods(service.Namespace), ns.synced()
+}
+
+// Stop watching Pods for a Service, and the Pods of its namespace if no other Service needs them.
+func (c *Controller) unwatchPods(service *corev1.Service) {
+	c.pods.Lock()
+	defer c.pods.Unlock()
+
+	ns, ok := c.pods.namespaces[service.Namespace]
+	if !ok {
+		return
+	}
+	delete(ns.services, service.Name)
+	if len(ns.services) == 0 {
+		log.Infof("no service in namespace '%s' uses readiness probes, not watching its pods anymore", service.Namespace)
+		close(ns.stop)
+		delete(c.pods.namespaces, service.Namespace)
+	}
+}
+
+// Must be called with the lock held.
+func (c *Controller) startPodInformer(namespace string) *namespacePods {
+	log.Infof("watching the pods in namespace '%s' for readiness probes", namespace)
+
+	factory := kubernetesinformers.NewSharedInformerFactoryWithOptions(c.Kubernetes, time.Second*30, kubernetesinformers.WithNamespace(namespace))
+	PodInformer := factory.Core().V1().Pods()
+
+	ns := &namespacePods{
+		lister:   PodInformer.Lister(),
+		synced:   PodInformer.Informer().HasSynced,
+		stop:     make(chan struct{}),
+		services: map[string]labels.Selector{},
+	}
+
+	PodInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if pod, ok := obj.(*corev1.Pod); ok {
+				c.wakeUpPodServices(pod)
+			}
+		},
+		UpdateFunc: func(old, new interface{}) {
+			oldPod, ok := old.(*corev1.Pod)
+			if !ok {
+				return
+			}
+			newPod, ok := new.(*corev1.Pod)
+			if !ok {
+				return
+			}
+			if reflect.DeepEqual(oldPod.Labels, newPod.Labels) && reflect.DeepEqual(readinessProbes(oldPod), readinessProbes(newPod)) {
+				return
+			}
+			c.wakeUpPodServices(oldPod)
+			c.wakeUpPodServices(newPod)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if pod, ok := deletedObject(obj).(*corev1.Pod); ok {
+				c.wakeUpPodServices(pod)
+			}
+		},
+	})
+
+	go factory.Start(ns.stop)
+
+	// process the Services waiting for the Pods, even if none of them has a readiness probe
+	go func() {
+		if !cache.WaitForCacheSync(ns.stop, ns.synced) {
+			return
+		}
+		c.pods.Lock()
+		defer c.pods.Unlock()
+		for name := range ns.services {
+			c.ServiceQueue.Add(namespace + "/" + name)
+		}
+	}()
+
+	return ns
+}
+
+// Wake up the Services that use the readiness probes and select the Pod, so their health
+// monitors follow new, changed and removed Pods.
+func (c *Controller) wakeUpPodServices(pod *corev1.Pod) {
+	if !hasHTTPReadinessProbe(pod) {
+		return
+	}
+
+	c.pods.Lock()
+	defer c.pods.Unlock()
+
+	ns, ok := c.pods.namespaces[pod.Namespace]
+	if !ok {
+		return
+	}
+
+	for name, selector := range ns.services {
+		if selector.Matches(labels.Set(pod.Labels)) {
+			log.Debugf("pod '%s-%s' changed, waking up service '%s-%s'", pod.Namespace, pod.Name, pod.Namespace, name)
+			c.ServiceQueue.Add(pod.Namespace + "/" + name)
+		}
+	}
+}
+
+// The readiness probes of the containers of a Pod, by container.
+func readinessProbes(pod *corev1.Pod) map[string]*corev1.Probe {
+	probes := map[string]*corev1.Probe{}
+	for _, container := range pod.Spec.Containers {
+		probes[container.Name] = container.ReadinessProbe
+	}
+	return probes
+}
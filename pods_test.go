@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"testing"
+	"time"
+)
+
+func (c *Controller) watchesPods(namespace string) bool {
+	c.pods.Lock()
+	defer c.pods.Unlock()
+	_, ok := c.pods.namespaces[namespace]
+	return ok
+}
+
+// Test that Pods are only watched in namespaces with Services that use the readiness probes
+func TestPodsWatchedForProbes(t *testing.T) {
+	c := testEnvironment()
+	a := assert.New(t)
+
+	annotated := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "annotated",
+			Namespace:   "default",
+			Annotations: map[string]string{AnnNxHealthPath: "/healthz"},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeNodePort,
+			Selector: map[string]string{"app": "annotated"},
+			Ports:    []corev1.ServicePort{{Port: 80, NodePort: 33979}},
+		},
+	}
+
+	if _, err := c.Kubernetes.CoreV1().Services("default").Create(annotated); !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	a.False(c.watchesPods("default"))
+
+	probed := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "probed",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeNodePort,
+			Selector: map[string]string{"app": "probed"},
+			Ports:    []corev1.ServicePort{{Port: 80, NodePort: 33978}},
+		},
+	}
+
+	if _, err := c.Kubernetes.CoreV1().Services("default").Create(probed); !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	a.True(c.watchesPods("default"))
+
+	if err := c.Kubernetes.CoreV1().Services("default").Delete("probed", &metav1.DeleteOptions{}); !a.Nil(err) {
+		return
+	}
+
+	time.Sleep(2 * time.Second)
+
+	a.False(c.watchesPods("default"))
+}
+
+// Test that relabeling a Pod updates the health monitor of the Services that select it
+func TestHealthMonitorFromRelabeledPod(t *testing.T) {
+	c := testEnvironment()
+	a := assert.New(t)
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "mypod",
+			Namespace: "default",
+			Labels:    map[string]string{"app": "other"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name: "web",
+					ReadinessProbe: &corev1.Probe{
+						Handler: corev1.Handler{
+							HTTPGet: &corev1.HTTPGetAction{Path: "/ready", Port: intstr.FromInt(8080)},
+						},
+						PeriodSeconds: 5,
+					},
+				},
+			},
+		},
+	}
+
+	pod, err := c.Kubernetes.CoreV1().Pods("default").Create(pod)
+	if !a.Nil(err) {
+		return
+	}
+
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "probed",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeNodePort,
+			Selector: map[string]string{"app": "probed"},
+			Ports:    []corev1.ServicePort{{Port: 80, TargetPort: intstr.FromInt(8080), NodePort: 33978}},
+		},
+	}
+
+	if _, err := c.Kubernetes.CoreV1().Services("default").Create(s); !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	monitors := func() []F5HealthMonitor {
+		cm, err := c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-probed-80", metav1.GetOptions{})
+		if !a.Nil(err) {
+			return nil
+		}
+		var vServer F5VirtualServerConfig
+		a.Nil(json.Unmarshal([]byte(cm.Data["data"]), &vServer))
+		return vServer.VirtualServer.Backend.HealthMonitors
+	}
+
+	a.Empty(monitors())
+
+	pod = pod.DeepCopy()
+	pod.Labels["app"] = "probed"
+	if _, err := c.Kubernetes.CoreV1().Pods("default").Update(pod); !a.Nil(err) {
+		return
+	}
+
+	time.Sleep(2 * time.Second)
+
+	a.Equal([]F5HealthMonitor{{Protocol: "http", Send: "GET /ready HTTP/1.0\r\n\r\n", Interval: 5, Timeout: 16}}, monitors())
+}
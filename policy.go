@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+
+	corev1 "k8s.io/api/core/v1"
+
+	bigipv1 "github.com/Nexinto/k8s-bigip-ipam/pkg/apis/bigip.nexinto.com/v1"
+)
+
+// Name of the VirtualServerPolicy (in the same Namespace) with the settings for a Service.
+const AnnNxVSPolicy = "nexinto.com/vip-policy"
+
+// The defaults for the annotations of a Service. The settings of the VirtualServerPolicy
+// referenced by the Service take precedence over the annotations of its Namespace.
+// Returns an error if the Service references a policy that doesn't exist.
+func (c *Controller) defaultsFor(service *corev1.Service) (map[string]string, error) {
+	namespace, err := c.namespaceOf(service)
+	if err != nil {
+		return nil, err
+	}
+
+	defaults := map[string]string{}
+	for _, ann := range namespaceDefaultAnnotations {
+		if v := namespace.Annotations[ann]; v != "" {
+			defaults[ann] = v
+		}
+	}
+
+	name := service.Annotations[AnnNxVSPolicy]
+	if name == "" {
+		return defaults, nil
+	}
+
+	policy, err := c.VirtualServerPolicyLister.VirtualServerPolicies(service.Namespace).Get(name)
+	if errors.IsNotFound(err) {
+		return nil, fmt.Errorf("VirtualServerPolicy '%s' in annotation %s not found", name, AnnNxVSPolicy)
+	} else if err != nil {
+		return nil, err
+	}
+
+	for k, v := range policyAnnotations(policy) {
+		defaults[k] = v
+	}
+
+	return defaults, nil
+}
+
+// The settings of a VirtualServerPolicy, as the annotations they correspond to.
+func policyAnnotations(policy *bigipv1.VirtualServerPolicy) map[string]string {
+	annotations := map[string]string{}
+
+	set := func(ann, v string) {
+		if v != "" {
+			annotations[ann] = v
+		}
+	}
+
+	set(AnnNxVipMode, policy.Spec.Mode)
+	set(AnnNxBalance, policy.Spec.Balance)
+	set(AnnNxSSLProfiles, strings.Join(policy.Spec.SSLProfiles, ","))
+	set(AnnNxPartition, policy.Spec.Partition)
+
+	if m := policy.Spec.Monitor; m != nil {
+		set(AnnNxHealthProtocol, m.Protocol)
+		set(AnnNxHealthPath, m.Path)
+		set(AnnNxHealthSend, m.Send)
+		if m.Interval != 0 {
+			set(AnnNxHealthInterval, strconv.Itoa(int(m.Interval)))
+		}
+		if m.Timeout != 0 {
+			set(AnnNxHealthTimeout, strconv.Itoa(int(m.Timeout)))
+		}
+	}
+
+	return annotations
+}
+
+func (c *Controller) VirtualServerPolicyCreatedOrUpdated(policy *bigipv1.VirtualServerPolicy) (err error) {
+	log.Debugf("processing virtualserverpolicy '%s-%s'", policy.Namespace, policy.Name)
+	defer requeueOnError(c.VirtualServerPolicyQueue, policy, &err)
+	return c.wakeUpPolicyServices(policy)
+}
+
+func (c *Controller) VirtualServerPolicyDeleted(policy *bigipv1.VirtualServerPolicy) error {
+	log.Debugf("processing deleted virtualserverpolicy '%s-%s'", policy.Namespace, policy.Name)
+	return c.wakeUpPolicyServices(policy)
+}
+
+// Process all Services that reference the policy.
+func (c *Controller) wakeUpPolicyServices(policy *bigipv1.VirtualServerPolicy) error {
+	services, err := c.ServiceLister.Services(policy.Namespace).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	for _, service := range services {
+		if service.Annotations[AnnNxVSPolicy] == policy.Name {
+			log.Debugf("waking up service '%s-%s'", service.Namespace, service.Name)
+			c.ServiceQueue.Add(service.Namespace + "/" + service.Name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	bigipv1 "github.com/Nexinto/k8s-bigip-ipam/pkg/apis/bigip.nexinto.com/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+// Test that Services use the settings of their VirtualServerPolicy and are updated when the policy changes
+func TestVirtualServerPolicy(t *testing.T) {
+	c := testEnvironment()
+	a := assert.New(t)
+
+	p := &bigipv1.VirtualServerPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "mypolicy",
+			Namespace: "default",
+		},
+		Spec: bigipv1.VirtualServerPolicySpec{
+			Balance: "least-connections-member",
+			Monitor: &bigipv1.HealthMonitor{
+				Protocol: "http",
+				Path:     "/health",
+			},
+		},
+	}
+
+	p, err := c.BigipClient.BigipV1().VirtualServerPolicies("default").Create(p)
+	if !a.Nil(err) {
+		return
+	}
+
+	for name, policy := range map[string]string{"myservice": "mypolicy", "otherservice": "unknown"} {
+		s := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "default",
+				Annotations: map[string]string{AnnNxVSPolicy: policy},
+			},
+			Spec: corev1.ServiceSpec{
+				Type: corev1.ServiceTypeNodePort,
+				Ports: []corev1.ServicePort{
+					{
+						Port:     80,
+						NodePort: 33978,
+					},
+				},
+			},
+		}
+
+		_, err := c.Kubernetes.CoreV1().Services("default").Create(s)
+		if !a.Nil(err) {
+			return
+		}
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	_, err = c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-otherservice-80", metav1.GetOptions{})
+	a.True(errors.IsNotFound(err))
+
+	f5, err := virtualServerConfig(c, "bigip-myservice-80")
+	if !a.Nil(err) {
+		return
+	}
+	a.Equal("least-connections-member", f5.VirtualServer.Frontend.Balance)
+	if a.Len(f5.VirtualServer.Backend.HealthMonitors, 1) {
+		a.Equal("GET /health HTTP/1.0\r\n\r\n", f5.VirtualServer.Backend.HealthMonitors[0].Send)
+	}
+
+	p.Spec.Balance = "ratio-member"
+	_, err = c.BigipClient.BigipV1().VirtualServerPolicies("default").Update(p)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	f5, err = virtualServerConfig(c, "bigip-myservice-80")
+	if !a.Nil(err) {
+		return
+	}
+	a.Equal("ratio-member", f5.VirtualServer.Frontend.Balance)
+}
+
+func virtualServerConfig(c *Controller, name string) (*F5VirtualServerConfig, error) {
+	cm, err := c.Kubernetes.CoreV1().ConfigMaps("default").Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	f5 := &F5VirtualServerConfig{}
+	err = json.Unmarshal([]byte(cm.Data["data"]), f5)
+	return f5, err
+}
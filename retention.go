@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Nexinto/k8s-lbutil"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+)
+
+const (
+	// With VIP_RETENTION, IpAddresses are annotated with the name of their Service instead of being owned by it
+	AnnNxRetainedFor = "nexinto.com/vip-retained-for"
+
+	// When the Service of a retained IpAddress was deleted (RFC 3339)
+	AnnNxReleasedAt = "nexinto.com/vip-released-at"
+)
+
+// With VIP_RETENTION, prepare the IpAddress of a Service before lbutil.EnsureVIP uses it, so that it is
+// not removed with the Service. An existing IpAddress is only changed if it belongs to the Service or
+// its address is already assigned to the Service.
+// Returns true if the address was requested and the Service has to wait for IPAM.
+func (c *Controller) prepareIpAddress(service *corev1.Service) (bool, error) {
+	address, err := c.IpAddressLister.IpAddresses(service.Namespace).Get(service.Name)
+	if errors.IsNotFound(err) {
+		if c.VIPRetention == 0 {
+			return false, nil // requested by lbutil.EnsureVIP
+		}
+		address = &ipamv1.IpAddress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      service.Name,
+				Namespace: service.Namespace,
+			},
+		}
+		c.setRetention(address, service)
+		_, err = c.IpamClient.IpamV1().IpAddresses(service.Namespace).Create(address)
+		if errors.IsAlreadyExists(err) {
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+		log.Infof("requested address '%s-%s' for service '%s-%s'", address.Namespace, address.Name, service.Namespace, service.Name)
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if !belongsTo(address, service) && !assignedTo(address, service) {
+		if c.VIPRetention == 0 {
+			return false, nil // left to lbutil.EnsureVIP
+		}
+		return false, fmt.Errorf("IpAddress '%s' exists, but does not belong to this Service", address.Name)
+	}
+
+	newaddress := address.DeepCopy()
+	c.setRetention(newaddress, service)
+
+	if !reflect.DeepEqual(address.ObjectMeta, newaddress.ObjectMeta) {
+		_, err = c.IpamClient.IpamV1().IpAddresses(address.Namespace).Update(newaddress)
+		return false, err
+	}
+	return false, nil
+}
+
+// With VIP_RETENTION, replace the owner reference of an IpAddress of the Service by AnnNxRetainedFor,
+// so the address is not removed with the Service; without it, make the Service the owner again.
+func (c *Controller) setRetention(address *ipamv1.IpAddress, service *corev1.Service) {
+	if c.VIPRetention == 0 {
+		if address.Annotations[AnnNxRetainedFor] != service.Name {
+			return
+		}
+		delete(address.Annotations, AnnNxRetainedFor)
+		delete(address.Annotations, AnnNxReleasedAt)
+		if !ownedBy(address, service) {
+			address.OwnerReferences = append(address.OwnerReferences, metav1.OwnerReference{
+				Kind:       "Service",
+				APIVersion: "v1",
+				Name:       service.Name,
+				UID:        service.GetUID(),
+			})
+		}
+		return
+	}
+
+	if address.Annotations == nil {
+		address.Annotations = map[string]string{}
+	}
+	address.Annotations[AnnNxRetainedFor] = service.Name
+	delete(address.Annotations, AnnNxReleasedAt)
+
+	owners := []metav1.OwnerReference{}
+	for _, ref := range address.OwnerReferences {
+		if ref.Kind != "Service" {
+			owners = append(owners, ref)
+		}
+	}
+	address.OwnerReferences = owners
+}
+
+// Does the IpAddress belong to the Service, as its owner or as a retained address?
+func belongsTo(address *ipamv1.IpAddress, service *corev1.Service) bool {
+	return ownedBy(address, service) || address.Annotations[AnnNxRetainedFor] == service.Name
+}
+
+// Is the address of the IpAddress the VIP of the Service? Addresses requested by lbutil.EnsureVIP
+// before VIP_RETENTION was enabled are taken over this way, whatever their owner references.
+func assignedTo(address *ipamv1.IpAddress, service *corev1.Service) bool {
+	return address.Status.Address != "" && address.Status.Address == service.Annotations[lbutil.AnnNxAssignedVIP]
+}
+
+// Start the grace period for the retained addresses of a deleted Service.
+func (c *Controller) releaseRetainedAddresses(service *corev1.Service) error {
+	for _, name := range []string{service.Name, ipv6AddressName(service)} {
+		address, err := c.IpAddressLister.IpAddresses(service.Namespace).Get(name)
+		if errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if address.Annotations[AnnNxRetainedFor] != service.Name {
+			continue
+		}
+		if _, err := c.expireRetainedAddress(address); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handle a retained IpAddress whose Service is gone: the address is kept for VIP_RETENTION after the
+// Service was deleted, then it is removed. Returns false if the Service exists.
+func (c *Controller) expireRetainedAddress(address *ipamv1.IpAddress) (bool, error) {
+	name := address.Annotations[AnnNxRetainedFor]
+
+	_, err := c.ServiceLister.Services(address.Namespace).Get(name)
+	if err == nil {
+		return false, nil
+	} else if !errors.IsNotFound(err) {
+		return false, err
+	}
+
+	key := address.Namespace + "/" + address.Name
+
+	releasedAt, err := time.Parse(time.RFC3339, address.Annotations[AnnNxReleasedAt])
+	if err != nil {
+		log.Infof("keeping address '%s-%s' (%s) of deleted service '%s-%s' for %s", address.Namespace, address.Name, address.Status.Address, address.Namespace, name, c.VIPRetention)
+		newaddress := address.DeepCopy()
+		newaddress.Annotations[AnnNxReleasedAt] = time.Now().UTC().Format(time.RFC3339)
+		c.IpAddressQueue.AddAfter(key, c.VIPRetention)
+		_, err = c.IpamClient.IpamV1().IpAddresses(address.Namespace).Update(newaddress)
+		return true, err
+	}
+
+	if remaining := c.VIPRetention - time.Since(releasedAt); remaining > 0 {
+		c.IpAddressQueue.AddAfter(key, remaining)
+		return true, nil
+	}
+
+	log.Infof("releasing address '%s-%s' (%s) of deleted service '%s-%s'", address.Namespace, address.Name, address.Status.Address, address.Namespace, name)
+	err = c.IpamClient.IpamV1().IpAddresses(address.Namespace).Delete(address.Name, &metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		return true, nil
+	}
+	return true, err
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+	"github.com/Nexinto/k8s-lbutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+// Test that an IpAddress that doesn't belong to the Service is not taken over
+func TestForeignIpAddress(t *testing.T) {
+	c := testEnvironment()
+	c.VIPRetention = time.Hour
+	a := assert.New(t)
+
+	_, err := c.IpamClient.IpamV1().IpAddresses("default").Create(&ipamv1.IpAddress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "myservice",
+			Namespace: "default",
+		},
+	})
+	if !a.Nil(err) {
+		return
+	}
+
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "myservice",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80, NodePort: 32080}},
+		},
+	}
+
+	_, err = c.Kubernetes.CoreV1().Services("default").Create(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	address, err := c.IpamClient.IpamV1().IpAddresses("default").Get("myservice", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.Empty(address.Annotations[AnnNxRetainedFor])
+	a.Empty(address.OwnerReferences)
+
+	_, err = c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-myservice-80", metav1.GetOptions{})
+	a.True(errors.IsNotFound(err))
+}
+
+// Test that a recreated Service gets its old VIP back, and that the address is released after the retention period.
+func TestVIPRetention(t *testing.T) {
+	c := testEnvironment()
+	c.VIPRetention = 6 * time.Second
+	a := assert.New(t)
+
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "myservice",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80, NodePort: 32080}},
+		},
+	}
+
+	_, err := c.Kubernetes.CoreV1().Services("default").Create(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	address, err := c.IpamClient.IpamV1().IpAddresses("default").Get("myservice", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.Equal("myservice", address.Annotations[AnnNxRetainedFor])
+	a.Empty(address.OwnerReferences)
+
+	s, err = c.Kubernetes.CoreV1().Services("default").Get("myservice", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	vip := s.Annotations[lbutil.AnnNxAssignedVIP]
+	a.NotEmpty(vip)
+	a.Equal(address.Status.Address, vip)
+
+	if err := c.Kubernetes.CoreV1().Services("default").Delete("myservice", &metav1.DeleteOptions{}); !a.Nil(err) {
+		return
+	}
+
+	time.Sleep(2 * time.Second)
+
+	address, err = c.IpamClient.IpamV1().IpAddresses("default").Get("myservice", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.NotEmpty(address.Annotations[AnnNxReleasedAt])
+
+	// recreate the Service
+	s = &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "myservice",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80, NodePort: 32080}},
+		},
+	}
+
+	_, err = c.Kubernetes.CoreV1().Services("default").Create(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	s, err = c.Kubernetes.CoreV1().Services("default").Get("myservice", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.Equal(vip, s.Annotations[lbutil.AnnNxAssignedVIP])
+
+	address, err = c.IpamClient.IpamV1().IpAddresses("default").Get("myservice", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.Empty(address.Annotations[AnnNxReleasedAt])
+
+	// delete it again and wait for the address to be released
+	if err := c.Kubernetes.CoreV1().Services("default").Delete("myservice", &metav1.DeleteOptions{}); !a.Nil(err) {
+		return
+	}
+
+	time.Sleep(c.VIPRetention + 3*time.Second)
+
+	_, err = c.IpamClient.IpamV1().IpAddresses("default").Get("myservice", metav1.GetOptions{})
+	a.True(errors.IsNotFound(err))
+}
+
+// Test that enabling VIP_RETENTION keeps the VIPs of existing Services, whatever the owner references
+// of their IpAddresses
+func TestRetentionForExistingService(t *testing.T) {
+	c := testEnvironment()
+	a := assert.New(t)
+
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "myservice",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80, NodePort: 32080}},
+		},
+	}
+
+	_, err := c.Kubernetes.CoreV1().Services("default").Create(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	s, err = c.Kubernetes.CoreV1().Services("default").Get("myservice", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	vip := s.Annotations[lbutil.AnnNxVIP]
+	a.NotEmpty(vip)
+
+	address, err := c.IpamClient.IpamV1().IpAddresses("default").Get("myservice", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	address.OwnerReferences = nil
+	if _, err := c.IpamClient.IpamV1().IpAddresses("default").Update(address); !a.Nil(err) {
+		return
+	}
+
+	c.VIPRetention = time.Hour
+	c.ServiceQueue.Add("default/myservice")
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	address, err = c.IpamClient.IpamV1().IpAddresses("default").Get("myservice", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.Equal("myservice", address.Annotations[AnnNxRetainedFor])
+	a.Equal(vip, address.Status.Address)
+
+	s, err = c.Kubernetes.CoreV1().Services("default").Get("myservice", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.Equal(VIPStateReady, s.Annotations[AnnNxVIPState])
+	a.Equal(vip, s.Annotations[lbutil.AnnNxVIP])
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Nexinto/k8s-lbutil"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	routev1 "github.com/openshift/api/route/v1"
+	routeclientset "github.com/openshift/client-go/route/clientset/versioned"
+	routeinformers "github.com/openshift/client-go/route/informers/externalversions"
+	routelisterv1 "github.com/openshift/client-go/route/listers/route/v1"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+)
+
+const (
+	// k8s-bigip-ctlr selects the Routes it manages with this label (--route-label).
+	// We use it to group Routes that share a VIP.
+	LabelRouteGroup = "f5type"
+
+	// Name of the route group for Routes without the route group label.
+	DefaultRouteGroup = "default"
+
+	// The route group of an address or ConfigMap, whose name may only be derived from the group.
+	AnnNxRouteGroup = "nexinto.com/route-group"
+)
+
+// OpenShift Route support is optional, so it is set up separately from the generated controller.
+type RouteSupport struct {
+	Client  routeclientset.Interface
+	Factory routeinformers.SharedInformerFactory
+
+	Queue  workqueue.RateLimitingInterface
+	Lister routelisterv1.RouteLister
+	Synced cache.InformerSynced
+}
+
+// Expects c.Initialize() to be called before.
+func (c *Controller) InitializeRoutes(client routeclientset.Interface) {
+	r := &RouteSupport{Client: client}
+	r.Factory = routeinformers.NewSharedInformerFactory(client, time.Second*30)
+
+	RouteInformer := r.Factory.Route().V1().Routes()
+	RouteQueue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Route")
+	r.Queue = RouteQueue
+	r.Lister = RouteInformer.Lister()
+	r.Synced = RouteInformer.Informer().HasSynced
+
+	// The queue holds route groups (namespace/group) instead of Routes. A Route that moves to
+	// another group also updates the owners of its old group.
+	RouteInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+
+		AddFunc: func(obj interface{}) {
+			if route, ok := obj.(*routev1.Route); ok {
+				RouteQueue.Add(routeGroupKey(route))
+			}
+		},
+
+		UpdateFunc: func(old, new interface{}) {
+			oldRoute, ok := old.(*routev1.Route)
+			if !ok {
+				return
+			}
+			newRoute, ok := new.(*routev1.Route)
+			if !ok {
+				return
+			}
+			RouteQueue.Add(routeGroupKey(newRoute))
+			if routeGroupKey(oldRoute) != routeGroupKey(newRoute) {
+				RouteQueue.Add(routeGroupKey(oldRoute))
+			}
+		},
+
+		DeleteFunc: func(obj interface{}) {
+			route, ok := obj.(*routev1.Route)
+			if !ok {
+				tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+				if !ok {
+					log.Errorf("couldn't get object from tombstone %+v", obj)
+					return
+				}
+				route, ok = tombstone.Obj.(*routev1.Route)
+				if !ok {
+					log.Errorf("tombstone contained object that is not a Route %+v", obj)
+					return
+				}
+			}
+			RouteQueue.Add(routeGroupKey(route))
+		},
+	})
+
+	c.Routes = r
+}
+
+// Start the Route informer and worker; returns when stopCh is closed.
+func (c *Controller) RunRoutes(stopCh <-chan struct{}) {
+	if c.Routes == nil {
+		return
+	}
+
+	log.Infof("starting route support")
+
+	defer runtime.HandleCrash()
+	defer c.Routes.Queue.ShutDown()
+
+	go c.Routes.Factory.Start(stopCh)
+
+	if !cache.WaitForCacheSync(stopCh, c.Routes.Synced) {
+		runtime.HandleError(fmt.Errorf("Timed out waiting for route caches to sync"))
+		return
+	}
+
+	go wait.Until(c.runRouteWorker, time.Second, stopCh)
+
+	<-stopCh
+}
+
+func (c *Controller) runRouteWorker() {
+	for c.processNextRoute() {
+	}
+}
+
+func (c *Controller) processNextRoute() bool {
+	obj, shutdown := c.Routes.Queue.Get()
+	if shutdown {
+		return false
+	}
+
+	err := func(obj interface{}) error {
+		defer c.Routes.Queue.Done(obj)
+		var key string
+		var ok bool
+
+		if key, ok = obj.(string); !ok {
+			c.Routes.Queue.Forget(obj)
+			runtime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
+			return nil
+		}
+
+		if err := c.processRoute(key); err != nil {
+			if c.Routes.Queue.NumRequeues(obj) < maxRetries {
+				c.Routes.Queue.AddRateLimited(obj)
+				return fmt.Errorf("error syncing '%s': %s, requeuing", key, err.Error())
+			}
+			c.Routes.Queue.Forget(obj)
+			return fmt.Errorf("error syncing '%s': %s, giving up", key, err.Error())
+		}
+
+		c.Routes.Queue.Forget(obj)
+		return nil
+	}(obj)
+
+	if err != nil {
+		runtime.HandleError(err)
+	}
+
+	return true
+}
+
+func (c *Controller) processRoute(key string) error {
+
+	namespace, group, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return fmt.Errorf("could not parse route group %s: %s", key, err.Error())
+	}
+
+	return c.RouteGroupChanged(namespace, group)
+}
+
+// Every route group in a namespace gets one VIP and a ConfigMap with the settings for the
+// k8s-bigip-ctlr instance serving the group (see deploy/routes). The address and the ConfigMap
+// are owned by all Routes in the group, and removed when the group has no more Routes.
+func (c *Controller) RouteGroupChanged(namespace, group string) error {
+	log.Debugf("processing route group '%s-%s'", namespace, group)
+
+	routes, excluded, err := c.routesInGroup(namespace, group)
+	if err != nil {
+		return err
+	}
+	for _, r := range excluded {
+		if err := c.setRouteVIP(r, ""); err != nil {
+			return err
+		}
+	}
+	if len(routes) == 0 {
+		return c.deleteRouteGroup(namespace, group)
+	}
+
+	owners := make([]metav1.OwnerReference, 0, len(routes))
+	for _, r := range routes {
+		owners = append(owners, metav1.OwnerReference{
+			Kind:       "Route",
+			APIVersion: "route.openshift.io/v1",
+			Name:       r.Name,
+			UID:        r.GetUID(),
+		})
+	}
+
+	name := routeGroupAddressName(group)
+
+	address, err := c.IpAddressLister.IpAddresses(namespace).Get(name)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		address = &ipamv1.IpAddress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       namespace,
+				Annotations:     map[string]string{AnnNxRouteGroup: group},
+				OwnerReferences: owners,
+			},
+		}
+		_, err = c.IpamClient.IpamV1().IpAddresses(namespace).Create(address)
+		if err != nil {
+			return err
+		}
+		log.Infof("requested address '%s-%s' for route group '%s'", address.Namespace, address.Name, group)
+		return nil
+	}
+
+	if !ownedByRoutes(address) {
+		return fmt.Errorf("IpAddress '%s' exists, but does not belong to route group '%s'", name, group)
+	}
+
+	if !reflect.DeepEqual(address.OwnerReferences, owners) || address.Annotations[AnnNxRouteGroup] != group {
+		newaddress := address.DeepCopy()
+		newaddress.OwnerReferences = owners
+		if newaddress.Annotations == nil {
+			newaddress.Annotations = map[string]string{}
+		}
+		newaddress.Annotations[AnnNxRouteGroup] = group
+		_, err = c.IpamClient.IpamV1().IpAddresses(namespace).Update(newaddress)
+		if err != nil {
+			return err
+		}
+	}
+
+	vip := address.Status.Address
+	if vip == "" {
+		// Routes that moved from another group must not keep its VIP
+		for _, r := range routes {
+			if err := c.setRouteVIP(r, ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	wantedConfigMap := c.routeConfigMapFor(namespace, group, vip, owners)
+
+	configMap, err := c.Kubernetes.CoreV1().ConfigMaps(namespace).Get(wantedConfigMap.Name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		_, err = c.Kubernetes.CoreV1().ConfigMaps(namespace).Create(wantedConfigMap)
+		if err != nil {
+			return err
+		}
+		log.Infof("created configmap '%s-%s' for route group '%s' with virtual IP '%s'", wantedConfigMap.Namespace, wantedConfigMap.Name, group, vip)
+	} else if !ownedByRoutes(configMap) {
+		return fmt.Errorf("ConfigMap '%s' exists, but does not belong to route group '%s'", configMap.Name, group)
+	} else if !reflect.DeepEqual(configMap.Data, wantedConfigMap.Data) || !reflect.DeepEqual(configMap.OwnerReferences, owners) || configMap.Labels[LabelControllerTag] != c.Tag || configMap.Annotations[AnnNxRouteGroup] != group {
+		newConfigMap := configMap.DeepCopy()
+		newConfigMap.Data = wantedConfigMap.Data
+		newConfigMap.OwnerReferences = owners
+		if newConfigMap.Labels == nil {
+			newConfigMap.Labels = map[string]string{}
+		}
+		newConfigMap.Labels[LabelControllerTag] = c.Tag
+		if newConfigMap.Annotations == nil {
+			newConfigMap.Annotations = map[string]string{}
+		}
+		newConfigMap.Annotations[AnnNxRouteGroup] = group
+		_, err = c.Kubernetes.CoreV1().ConfigMaps(namespace).Update(newConfigMap)
+		if err != nil {
+			return err
+		}
+		log.Infof("updated configmap '%s-%s' for route group '%s'", configMap.Namespace, configMap.Name, group)
+	}
+
+	for _, r := range routes {
+		if err := c.setRouteVIP(r, vip); err != nil {
+			return err
+		}
+	}
+
+	// ConfigMaps of older versions were named bigip-routes-GROUP
+	return c.deleteRouteGroupConfigMap(namespace, legacyRouteGroupConfigMapName(group))
+}
+
+// Set the VIP annotation of a Route, or remove it if vip is empty.
+func (c *Controller) setRouteVIP(route *routev1.Route, vip string) error {
+	if route.Annotations[lbutil.AnnNxVIP] == vip {
+		return nil
+	}
+	newroute := route.DeepCopy()
+	if vip == "" {
+		delete(newroute.Annotations, lbutil.AnnNxVIP)
+	} else {
+		if newroute.Annotations == nil {
+			newroute.Annotations = map[string]string{}
+		}
+		newroute.Annotations[lbutil.AnnNxVIP] = vip
+	}
+	_, err := c.Routes.Client.RouteV1().Routes(route.Namespace).Update(newroute)
+	if err != nil {
+		return err
+	}
+	if vip == "" {
+		log.Infof("route '%s-%s' is not served on a virtual IP anymore", route.Namespace, route.Name)
+	} else {
+		log.Infof("route '%s-%s' is served on virtual IP '%s'", route.Namespace, route.Name, vip)
+	}
+	return nil
+}
+
+// Remove the address and the ConfigMap of a route group without Routes.
+func (c *Controller) deleteRouteGroup(namespace, group string) error {
+	address, err := c.IpAddressLister.IpAddresses(namespace).Get(routeGroupAddressName(group))
+	if err == nil && ownedByRoutes(address) {
+		log.Infof("releasing address '%s-%s' of empty route group '%s'", address.Namespace, address.Name, group)
+		err = c.IpamClient.IpamV1().IpAddresses(namespace).Delete(address.Name, &metav1.DeleteOptions{})
+	}
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	for _, name := range []string{routeGroupConfigMapName(group), legacyRouteGroupConfigMapName(group)} {
+		if err := c.deleteRouteGroupConfigMap(namespace, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Controller) deleteRouteGroupConfigMap(namespace, name string) error {
+	configMap, err := c.Kubernetes.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if err == nil && ownedByRoutes(configMap) {
+		log.Infof("deleting configmap '%s-%s'", configMap.Namespace, configMap.Name)
+		err = c.Kubernetes.CoreV1().ConfigMaps(namespace).Delete(name, &metav1.DeleteOptions{})
+	}
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// The settings for the k8s-bigip-ctlr instance that serves a route group; the keys are the
+// names of the k8s-bigip-ctlr command line options. deploy/routes/k8s-bigip-ctlr-routes.yaml
+// passes them to k8s-bigip-ctlr.
+func (c *Controller) routeConfigMapFor(namespace, group, vip string, owners []metav1.OwnerReference) *corev1.ConfigMap {
+	data := map[string]string{
+		"route-vserver-addr": vip,
+		"bigip-partition":    c.Partition,
+		"namespace":          namespace,
+	}
+
+	if group != DefaultRouteGroup {
+		data["route-label"] = group
+	}
+
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            routeGroupConfigMapName(group),
+			Namespace:       namespace,
+			OwnerReferences: owners,
+			Labels:          map[string]string{LabelControllerTag: c.Tag},
+			Annotations:     map[string]string{AnnNxRouteGroup: group},
+		},
+		Data: data,
+	}
+}
+
+// All Routes of a route group, sorted by name, and the Routes with the group label that
+// don't request a VIP.
+func (c *Controller) routesInGroup(namespace, group string) ([]*routev1.Route, []*routev1.Route, error) {
+	all, err := c.Routes.Lister.Routes(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	routes := []*routev1.Route{}
+	excluded := []*routev1.Route{}
+	for _, route := range all {
+		if routeGroupOf(route) != group {
+			continue
+		}
+		if route.DeletionTimestamp != nil {
+			continue
+		}
+		if c.RequireTag && route.Annotations[AnnNxReqVIP] != "true" {
+			excluded = append(excluded, route)
+			continue
+		}
+		routes = append(routes, route)
+	}
+
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Name < routes[j].Name })
+
+	return routes, excluded, nil
+}
+
+func routeGroupOf(route *routev1.Route) string {
+	if g := route.Labels[LabelRouteGroup]; g != "" {
+		return g
+	}
+	return DefaultRouteGroup
+}
+
+// The workqueue key of the route group of a Route.
+func routeGroupKey(route *routev1.Route) string {
+	return route.Namespace + "/" + routeGroupOf(route)
+}
+
+// Service names cannot contain dots, so this cannot collide with the address of a Service.
+func routeGroupAddressName(group string) string {
+	return routeGroupObjectName("routes.", group)
+}
+
+// Service and port names cannot contain dots, so this cannot collide with the ConfigMap of a Service.
+func routeGroupConfigMapName(group string) string {
+	return routeGroupObjectName("bigip-routes.", group)
+}
+
+// Label values may contain uppercase letters and underscores, which are not allowed in object
+// names. Such groups get a lowercase name with a hash of the group to keep it unique.
+func routeGroupObjectName(prefix, group string) string {
+	if name := prefix + group; len(validation.IsDNS1123Subdomain(name)) == 0 {
+		return name
+	}
+
+	sanitised := strings.Trim(strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, group), "-")
+
+	hash := sha256.Sum256([]byte(group))
+	if sanitised == "" {
+		return prefix + hex.EncodeToString(hash[:4])
+	}
+	return prefix + sanitised + "-" + hex.EncodeToString(hash[:4])
+}
+
+func legacyRouteGroupConfigMapName(group string) string {
+	return "bigip-routes-" + group
+}
+
+// Is the object owned by Routes? The resources of route groups are owned only by Routes.
+func ownedByRoutes(o metav1.Object) bool {
+	refs := o.GetOwnerReferences()
+	for _, ref := range refs {
+		if ref.Kind != "Route" {
+			return false
+		}
+	}
+	return len(refs) > 0
+}
+
+// Returns the route group of an address requested for a route group, if any.
+func routeGroupOfAddress(address *ipamv1.IpAddress) (string, bool) {
+	if !strings.HasPrefix(address.Name, "routes.") || !ownedByRoutes(address) {
+		return "", false
+	}
+	if group := address.Annotations[AnnNxRouteGroup]; group != "" {
+		return group, true
+	}
+	// addresses of older versions are only named after the group
+	return strings.TrimPrefix(address.Name, "routes."), true
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"github.com/Nexinto/k8s-lbutil"
+	routev1 "github.com/openshift/api/route/v1"
+	routefake "github.com/openshift/client-go/route/clientset/versioned/fake"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/cache"
+	"testing"
+)
+
+// Create a test environment with Route support enabled.
+func testRouteEnvironment() *Controller {
+	c := testEnvironment()
+
+	c.InitializeRoutes(routefake.NewSimpleClientset())
+
+	stopCh := make(chan struct{})
+
+	go c.RunRoutes(stopCh)
+
+	if !cache.WaitForCacheSync(stopCh, c.Routes.Synced) {
+		panic("Timed out waiting for route caches to sync")
+	}
+
+	return c
+}
+
+func testRoute(name, group string) *routev1.Route {
+	r := &routev1.Route{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{},
+		},
+		Spec: routev1.RouteSpec{
+			Host: name + ".example.com",
+			To:   routev1.RouteTargetReference{Kind: "Service", Name: name},
+		},
+	}
+	if group != "" {
+		r.Labels[LabelRouteGroup] = group
+	}
+	return r
+}
+
+// Test that every route group gets a VIP and the configuration for k8s-bigip-ctlr
+func TestRouteGroups(t *testing.T) {
+	c := testRouteEnvironment()
+	a := assert.New(t)
+
+	for _, r := range []*routev1.Route{testRoute("web", ""), testRoute("api", ""), testRoute("shop", "public")} {
+		_, err := c.Routes.Client.RouteV1().Routes("default").Create(r)
+		if !a.Nil(err) {
+			return
+		}
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	vips := map[string]string{}
+
+	for _, group := range []string{"default", "public"} {
+		ia, err := c.IpamClient.IpamV1().IpAddresses("default").Get("routes."+group, metav1.GetOptions{})
+		if !a.Nil(err) {
+			return
+		}
+		if !a.NotEmpty(ia.Status.Address) {
+			return
+		}
+		vips[group] = ia.Status.Address
+
+		cm, err := c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-routes."+group, metav1.GetOptions{})
+		if !a.Nil(err) {
+			return
+		}
+		a.Equal(ia.Status.Address, cm.Data["route-vserver-addr"])
+	}
+
+	a.NotEqual(vips["default"], vips["public"])
+
+	ia, err := c.IpamClient.IpamV1().IpAddresses("default").Get("routes.default", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.Len(ia.OwnerReferences, 2)
+
+	cm, err := c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-routes.public", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.Equal("public", cm.Data["route-label"])
+
+	for name, group := range map[string]string{"web": "default", "api": "default", "shop": "public"} {
+		r, err := c.Routes.Client.RouteV1().Routes("default").Get(name, metav1.GetOptions{})
+		if !a.Nil(err) {
+			return
+		}
+		a.Equal(vips[group], r.Annotations[lbutil.AnnNxVIP])
+	}
+}
+
+// Test that a Route moving to another route group updates the owners of its old group, and that
+// the resources of an empty route group are removed
+func TestRouteGroupRelabel(t *testing.T) {
+	c := testRouteEnvironment()
+	a := assert.New(t)
+
+	for _, r := range []*routev1.Route{testRoute("web", ""), testRoute("api", "")} {
+		_, err := c.Routes.Client.RouteV1().Routes("default").Create(r)
+		if !a.Nil(err) {
+			return
+		}
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	r, err := c.Routes.Client.RouteV1().Routes("default").Get("api", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	r.Labels[LabelRouteGroup] = "public"
+	_, err = c.Routes.Client.RouteV1().Routes("default").Update(r)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	ia, err := c.IpamClient.IpamV1().IpAddresses("default").Get("routes.default", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	if a.Len(ia.OwnerReferences, 1) {
+		a.Equal("web", ia.OwnerReferences[0].Name)
+	}
+
+	cm, err := c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-routes.default", metav1.GetOptions{})
+	if a.Nil(err) {
+		a.Len(cm.OwnerReferences, 1)
+	}
+
+	_, err = c.IpamClient.IpamV1().IpAddresses("default").Get("routes.public", metav1.GetOptions{})
+	a.Nil(err)
+
+	if err := c.Routes.Client.RouteV1().Routes("default").Delete("web", &metav1.DeleteOptions{}); !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	_, err = c.IpamClient.IpamV1().IpAddresses("default").Get("routes.default", metav1.GetOptions{})
+	a.True(errors.IsNotFound(err))
+	_, err = c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-routes.default", metav1.GetOptions{})
+	a.True(errors.IsNotFound(err))
+}
+
+// Test that route groups that are not valid in object names get a valid, unique name
+func TestRouteGroupObjectNames(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal("routes.public", routeGroupAddressName("public"))
+	a.Equal("bigip-routes.public", routeGroupConfigMapName("public"))
+
+	names := map[string]bool{}
+	for _, group := range []string{"My_Group", "my-group", "my_group", "___", "a..b"} {
+		name := routeGroupAddressName(group)
+		a.Empty(validation.IsDNS1123Subdomain(name), group)
+		a.Empty(validation.IsDNS1123Subdomain(routeGroupConfigMapName(group)), group)
+		a.False(names[name], group)
+		names[name] = true
+	}
+}
+
+// Test that a Route loses its VIP annotation when it doesn't request a VIP anymore
+func TestRouteRequireTag(t *testing.T) {
+	c := testRouteEnvironment()
+	c.RequireTag = true
+	a := assert.New(t)
+
+	r := testRoute("web", "My_Group")
+	r.Annotations = map[string]string{AnnNxReqVIP: "true"}
+	if _, err := c.Routes.Client.RouteV1().Routes("default").Create(r); !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	r, err := c.Routes.Client.RouteV1().Routes("default").Get("web", metav1.GetOptions{})
+	if !a.Nil(err) || !a.NotEmpty(r.Annotations[lbutil.AnnNxVIP]) {
+		return
+	}
+
+	ia, err := c.IpamClient.IpamV1().IpAddresses("default").Get(routeGroupAddressName("My_Group"), metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	group, ok := routeGroupOfAddress(ia)
+	a.True(ok)
+	a.Equal("My_Group", group)
+
+	delete(r.Annotations, AnnNxReqVIP)
+	if _, err := c.Routes.Client.RouteV1().Routes("default").Update(r); !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	r, err = c.Routes.Client.RouteV1().Routes("default").Get("web", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.Empty(r.Annotations[lbutil.AnnNxVIP])
+
+	_, err = c.IpamClient.IpamV1().IpAddresses("default").Get(routeGroupAddressName("My_Group"), metav1.GetOptions{})
+	a.True(errors.IsNotFound(err))
+}
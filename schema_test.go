@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"github.com/Nexinto/k8s-lbutil"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+// Test the rendering of a virtual server for every supported schema version
+// against the golden files in testdata/schema
+func TestSchemaGolden(t *testing.T) {
+	a := assert.New(t)
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "myservice",
+			Namespace: "default",
+			Annotations: map[string]string{
+				lbutil.AnnNxAssignedVIP: "10.1.1.1",
+				AnnNxSSLProfiles:        "Common/mysite",
+			},
+		},
+	}
+
+	settings := &vsSettings{
+		ssl:         true,
+		sslProfiles: "Common/mysite",
+		mode:        F5ModeHTTP,
+		balance:     F5DefaultBalance,
+		partition:   "kubernetes",
+		monitor: &F5HealthMonitor{
+			Interval: 5,
+			Protocol: "http",
+			Send:     "GET / HTTP/1.0\r\n\r\n",
+			Timeout:  16,
+		},
+		frontendPorts:   map[int32]int32{8443: 443},
+		iRules:          []string{"/Common/redirect"},
+		policies:        []string{"/Common/waf"},
+		connectionLimit: 1000,
+	}
+
+	for version, schema := range F5Schemas {
+		c := &Controller{Partition: "kubernetes", Schema: schema}
+
+		configMap := c.configMapFor(service, settings, corev1.ServicePort{Port: 8443, Protocol: corev1.ProtocolTCP}, ipv4)
+		a.Equal("f5schemadb://bigip-virtual-server_v"+version+".json", configMap.Data["schema"])
+
+		golden := filepath.Join("testdata", "schema", "v"+version+".json")
+
+		if *updateGolden {
+			var v interface{}
+			if err := json.Unmarshal([]byte(configMap.Data["data"]), &v); !a.Nil(err) {
+				return
+			}
+			b, _ := json.MarshalIndent(v, "", "  ")
+			if err := ioutil.WriteFile(golden, append(b, '\n'), 0644); !a.Nil(err) {
+				return
+			}
+		}
+
+		expected, err := ioutil.ReadFile(golden)
+		if !a.Nil(err, version) {
+			continue
+		}
+
+		var actualValue, expectedValue interface{}
+		if err := json.Unmarshal([]byte(configMap.Data["data"]), &actualValue); !a.Nil(err, version) {
+			continue
+		}
+		if err := json.Unmarshal(expected, &expectedValue); !a.Nil(err, version) {
+			continue
+		}
+
+		a.Empty(jsonDiff("data", actualValue, expectedValue), version)
+	}
+}
+
+// Test that annotations for fields the schema doesn't know about are rejected
+func TestSchemaUnsupportedAnnotation(t *testing.T) {
+	a := assert.New(t)
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "myservice",
+			Namespace:   "default",
+			Annotations: map[string]string{AnnNxIRules: "/Common/redirect"},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{{Port: 80, NodePort: 33978}},
+		},
+	}
+
+	c := &Controller{Schema: F5Schemas["0.1.3"]}
+	_, err := c.settingsFor(service, nil)
+	a.NotNil(err)
+
+	c = &Controller{Schema: F5Schemas["0.1.7"]}
+	settings, err := c.settingsFor(service, nil)
+	if !a.Nil(err) {
+		return
+	}
+	a.Equal([]string{"/Common/redirect"}, settings.iRules)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const defaultBigIPTimeout = 5 * time.Minute
+
+// Values of the AnnNxVIPState annotation on Services.
+const (
+	// waiting for the IP address
+	VIPStatePendingIPAM = "pending-ipam"
+
+	// waiting for k8s-bigip-ctlr to configure the virtual servers
+	VIPStatePendingBigIP = "pending-bigip"
+
+	// the loadbalancing annotations are invalid, see the Events of the Service
+	VIPStateInvalid = "invalid-configuration"
+
+	VIPStateReady = "ready"
+)
+
+func (c *Controller) bigipTimeout() time.Duration {
+	if c.BigIPTimeout == 0 {
+		return defaultBigIPTimeout
+	}
+	return c.BigIPTimeout
+}
+
+// Set the state annotation of a Service; an empty state removes it.
+// Returns true if the Service was changed.
+func setVIPState(service *corev1.Service, state string) bool {
+	if service.Annotations[AnnNxVIPState] == state {
+		return false
+	}
+	if state == "" {
+		delete(service.Annotations, AnnNxVIPState)
+		return true
+	}
+	if service.Annotations == nil {
+		service.Annotations = map[string]string{}
+	}
+	service.Annotations[AnnNxVIPState] = state
+	return true
+}
+
+// When the configuration in a generated ConfigMap was last written. ConfigMaps written by
+// older versions, and pinned ConfigMaps that were never updated, fall back to their creation.
+func writtenAt(configMap *corev1.ConfigMap) time.Time {
+	if t, err := time.Parse(time.RFC3339, configMap.Annotations[AnnNxWrittenAt]); err == nil {
+		return t
+	}
+	if !configMap.CreationTimestamp.IsZero() {
+		return configMap.CreationTimestamp.Time
+	}
+	return time.Now()
+}
+
+// A port with a ConfigMap that k8s-bigip-ctlr has not processed yet.
+type pendingPort struct {
+	port    string // as returned by describePort
+	written time.Time
+}
+
+// Returns the ports that k8s-bigip-ctlr has not configured within the timeout. The Service
+// is processed again when the timeout for the other ports expires.
+func (c *Controller) checkBigIPTimeout(service *corev1.Service, pending []pendingPort) []string {
+	overdue := []string{}
+	var next time.Duration
+
+	for _, p := range pending {
+		remaining := c.bigipTimeout() - time.Since(p.written)
+		if remaining <= 0 {
+			overdue = append(overdue, p.port)
+		} else if next == 0 || remaining < next {
+			next = remaining
+		}
+	}
+
+	if next > 0 {
+		c.ServiceQueue.AddAfter(service.Namespace+"/"+service.Name, next)
+	}
+
+	if len(overdue) > 0 {
+		log.Warnf("k8s-bigip-ctlr has not configured port(s) %s of service '%s-%s' within %s", strings.Join(overdue, ", "), service.Namespace, service.Name, c.bigipTimeout())
+	}
+
+	return overdue
+}
+
+// Types of the conditions in the AnnNxVIPStatus annotation.
+const (
+	ConditionIPAllocated       = "IPAllocated"
+	ConditionConfigMapsWritten = "ConfigMapsWritten"
+	ConditionBigIPConfigured   = "BigIPConfigured"
+)
+
+// The content of the AnnNxVIPStatus annotation on Services.
+type VIPStatus struct {
+	Conditions []VIPCondition  `json:"conditions"`
+	Ports      []VIPPortStatus `json:"ports,omitempty"`
+}
+
+type VIPCondition struct {
+	Type               string                 `json:"type"`
+	Status             corev1.ConditionStatus `json:"status"`
+	Reason             string                 `json:"reason"`
+	Message            string                 `json:"message,omitempty"`
+	LastTransitionTime metav1.Time            `json:"lastTransitionTime"`
+}
+
+type VIPPortStatus struct {
+	Port      int32           `json:"port"`
+	Protocol  corev1.Protocol `json:"protocol"`
+	Family    string          `json:"family"`
+	ConfigMap string          `json:"configMap"`
+	Ready     bool            `json:"ready"`
+}
+
+func vipCondition(t string, ok bool, reason, message string) VIPCondition {
+	status := corev1.ConditionFalse
+	if ok {
+		status = corev1.ConditionTrue
+	}
+	return VIPCondition{Type: t, Status: status, Reason: reason, Message: message}
+}
+
+// Set the status annotation of a Service; nil removes it. Conditions keep their
+// transition time unless their status changes. Returns true if the Service was changed.
+func setVIPStatus(service *corev1.Service, status *VIPStatus) bool {
+	if status == nil {
+		if _, ok := service.Annotations[AnnNxVIPStatus]; !ok {
+			return false
+		}
+		delete(service.Annotations, AnnNxVIPStatus)
+		return true
+	}
+
+	old := &VIPStatus{}
+	json.Unmarshal([]byte(service.Annotations[AnnNxVIPStatus]), old)
+
+	now := metav1.NewTime(time.Now().UTC().Truncate(time.Second))
+	for i := range status.Conditions {
+		status.Conditions[i].LastTransitionTime = now
+		for _, o := range old.Conditions {
+			if o.Type == status.Conditions[i].Type && o.Status == status.Conditions[i].Status {
+				status.Conditions[i].LastTransitionTime = o.LastTransitionTime
+			}
+		}
+	}
+
+	sort.Slice(status.Ports, func(i, j int) bool {
+		a, b := status.Ports[i], status.Ports[j]
+		if a.Family != b.Family {
+			return a.Family < b.Family
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return a.Protocol < b.Protocol
+	})
+
+	b, err := json.Marshal(status)
+	if err != nil {
+		log.Errorf("error encoding status for service '%s-%s': %s", service.Namespace, service.Name, err.Error())
+		return false
+	}
+
+	if service.Annotations[AnnNxVIPStatus] == string(b) {
+		return false
+	}
+	if service.Annotations == nil {
+		service.Annotations = map[string]string{}
+	}
+	service.Annotations[AnnNxVIPStatus] = string(b)
+	return true
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/Nexinto/k8s-lbutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test the state annotation and the warning if k8s-bigip-ctlr does not configure the VIP in time
+func TestBigIPTimeout(t *testing.T) {
+	c := testEnvironment()
+	c.BigIPTimeout = 2 * time.Second
+	a := assert.New(t)
+
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "myservice",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80, NodePort: 32080}},
+		},
+	}
+
+	_, err := c.Kubernetes.CoreV1().Services("default").Create(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	time.Sleep(2 * time.Second)
+
+	s, err = c.Kubernetes.CoreV1().Services("default").Get("myservice", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.Equal(VIPStatePendingIPAM, s.Annotations[AnnNxVIPState])
+
+	if err := lbutil.SimIPAM(c.IpamClient); !a.Nil(err) {
+		return
+	}
+
+	time.Sleep(2 * time.Second)
+
+	cm, err := c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-myservice-80", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	_, err = time.Parse(time.RFC3339, cm.Annotations[AnnNxWrittenAt])
+	a.Nil(err)
+
+	s, err = c.Kubernetes.CoreV1().Services("default").Get("myservice", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.Equal(VIPStatePendingBigIP, s.Annotations[AnnNxVIPState])
+
+	time.Sleep(3 * time.Second)
+
+	c.serviceStates.Lock()
+	a.Equal(serviceBigIPTimeout, c.serviceStates.states["default/myservice"])
+	c.serviceStates.Unlock()
+
+	events, err := c.Kubernetes.CoreV1().Events("default").List(metav1.ListOptions{})
+	if !a.Nil(err) {
+		return
+	}
+
+	warned := false
+	for _, e := range events.Items {
+		if strings.HasPrefix(e.Message, "k8s-bigip-ctlr has not configured virtual IP") && strings.Contains(e.Message, "port(s) 80 ") {
+			a.Equal(corev1.EventTypeWarning, e.Type)
+			warned = true
+		}
+	}
+	a.True(warned)
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	s, err = c.Kubernetes.CoreV1().Services("default").Get("myservice", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.Equal(VIPStateReady, s.Annotations[AnnNxVIPState])
+
+	c.serviceStates.Lock()
+	a.Equal(serviceReady, c.serviceStates.states["default/myservice"])
+	c.serviceStates.Unlock()
+}
+
+// Test that a pinned ConfigMap that k8s-bigip-ctlr does not configure times out as well
+func TestPinnedConfigMapTimeout(t *testing.T) {
+	c := testEnvironment()
+	c.BigIPTimeout = 2 * time.Second
+	a := assert.New(t)
+
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "myservice",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80, NodePort: 32080}},
+		},
+	}
+
+	_, err := c.Kubernetes.CoreV1().Services("default").Create(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	cm, err := c.Kubernetes.CoreV1().ConfigMaps("default").Get("bigip-myservice-80", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	cm.Annotations[AnnNxPinned] = "true"
+	delete(cm.Annotations, AnnVirtualServerIPStatus)
+	_, err = c.Kubernetes.CoreV1().ConfigMaps("default").Update(cm)
+	if !a.Nil(err) {
+		return
+	}
+
+	// as on the next resync
+	time.Sleep(time.Second)
+	c.ServiceQueue.Add("default/myservice")
+	time.Sleep(time.Second)
+
+	s, err = c.Kubernetes.CoreV1().Services("default").Get("myservice", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	a.Equal(VIPStatePendingBigIP, s.Annotations[AnnNxVIPState])
+
+	c.serviceStates.Lock()
+	a.Equal(serviceBigIPTimeout, c.serviceStates.states["default/myservice"])
+	c.serviceStates.Unlock()
+}
+
+func TestSetVIPStatus(t *testing.T) {
+	a := assert.New(t)
+
+	s := &corev1.Service{}
+
+	a.True(setVIPStatus(s, &VIPStatus{Conditions: []VIPCondition{
+		vipCondition(ConditionIPAllocated, false, "Pending", ""),
+		vipCondition(ConditionBigIPConfigured, false, "Pending", ""),
+	}}))
+
+	old := &VIPStatus{}
+	if !a.Nil(json.Unmarshal([]byte(s.Annotations[AnnNxVIPStatus]), old)) {
+		return
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	a.False(setVIPStatus(s, &VIPStatus{Conditions: []VIPCondition{
+		vipCondition(ConditionIPAllocated, false, "Pending", ""),
+		vipCondition(ConditionBigIPConfigured, false, "Pending", ""),
+	}}))
+
+	a.True(setVIPStatus(s, &VIPStatus{Conditions: []VIPCondition{
+		vipCondition(ConditionIPAllocated, true, "Allocated", ""),
+		vipCondition(ConditionBigIPConfigured, false, "Pending", ""),
+	}}))
+
+	status := &VIPStatus{}
+	if !a.Nil(json.Unmarshal([]byte(s.Annotations[AnnNxVIPStatus]), status)) {
+		return
+	}
+	a.True(status.Conditions[0].LastTransitionTime.After(old.Conditions[0].LastTransitionTime.Time))
+	a.Equal(old.Conditions[1].LastTransitionTime, status.Conditions[1].LastTransitionTime)
+
+	a.True(setVIPStatus(s, nil))
+	_, found := s.Annotations[AnnNxVIPStatus]
+	a.False(found)
+}
+
+// Test the status annotation of a Service with two ports
+func TestVIPStatus(t *testing.T) {
+	c := testEnvironment()
+	a := assert.New(t)
+
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "myservice",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{
+				{Name: "https", Protocol: corev1.ProtocolTCP, Port: 443, NodePort: 32443},
+				{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80, NodePort: 32080},
+			},
+		},
+	}
+
+	_, err := c.Kubernetes.CoreV1().Services("default").Create(s)
+	if !a.Nil(err) {
+		return
+	}
+
+	if err := c.simulate(); !a.Nil(err) {
+		return
+	}
+
+	s, err = c.Kubernetes.CoreV1().Services("default").Get("myservice", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+
+	status := &VIPStatus{}
+	if !a.Nil(json.Unmarshal([]byte(s.Annotations[AnnNxVIPStatus]), status)) {
+		return
+	}
+
+	if a.Len(status.Conditions, 3) {
+		for i, ty := range []string{ConditionIPAllocated, ConditionConfigMapsWritten, ConditionBigIPConfigured} {
+			a.Equal(ty, status.Conditions[i].Type)
+			a.Equal(corev1.ConditionTrue, status.Conditions[i].Status)
+			a.False(status.Conditions[i].LastTransitionTime.IsZero())
+		}
+	}
+
+	a.Equal([]VIPPortStatus{
+		{Port: 80, Protocol: corev1.ProtocolTCP, Family: "IPv4", ConfigMap: "bigip-myservice-80", Ready: true},
+		{Port: 443, Protocol: corev1.ProtocolTCP, Family: "IPv4", ConfigMap: "bigip-myservice-443", Ready: true},
+	}, status.Ports)
+}
@@ -1,8 +1,18 @@
 package main
 
+import "fmt"
+
+type F5HealthMonitor struct {
+	Interval int32  `json:"interval,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	Send     string `json:"send,omitempty"`
+	Timeout  int32  `json:"timeout,omitempty"`
+}
+
 type F5Backend struct {
-	ServiceName string `json:"serviceName,omitempty"`
-	ServicePort int32  `json:"servicePort,omitempty"`
+	ServiceName    string            `json:"serviceName,omitempty"`
+	ServicePort    int32             `json:"servicePort,omitempty"`
+	HealthMonitors []F5HealthMonitor `json:"healthMonitors,omitempty"`
 }
 
 type F5VirtualAddress struct {
@@ -20,14 +30,45 @@ type F5Mode string
 const (
 	F5ModeHTTP F5Mode = "http"
 	F5ModeTCP  F5Mode = "tcp"
+	F5ModeUDP  F5Mode = "udp"
 )
 
+// Loadbalancing algorithms supported by the BIG-IP and k8s-bigip-ctlr.
+var F5BalanceAlgorithms = map[string]bool{
+	"round-robin":                       true,
+	"ratio-member":                      true,
+	"ratio-node":                        true,
+	"ratio-session":                     true,
+	"ratio-least-connections-member":    true,
+	"ratio-least-connections-node":      true,
+	"fastest-node":                      true,
+	"fastest-app-response":              true,
+	"least-connections-member":          true,
+	"least-connections-node":            true,
+	"least-sessions":                    true,
+	"observed-member":                   true,
+	"observed-node":                     true,
+	"predictive-member":                 true,
+	"predictive-node":                   true,
+	"dynamic-ratio-member":              true,
+	"dynamic-ratio-node":                true,
+	"weighted-least-connections-member": true,
+	"weighted-least-connections-node":   true,
+}
+
+const F5DefaultBalance = "round-robin"
+
 type F5Frontend struct {
 	Balance        string           `json:"balance,omitempty"`
 	Mode           F5Mode           `json:"mode,omitempty"`
 	Partition      string           `json:"partition,omitempty"`
 	VirtualAddress F5VirtualAddress `json:"virtualAddress,omitempty"`
 	SSLProfile     *F5SSLProfile    `json:"sslProfile,omitempty"`
+
+	// since schema v0.1.7
+	IRules          []string `json:"iRules,omitempty"`
+	Policies        []string `json:"policies,omitempty"`
+	ConnectionLimit int32    `json:"connectionLimit,omitempty"`
 }
 
 type F5VirtualServer struct {
@@ -38,3 +79,24 @@ type F5VirtualServer struct {
 type F5VirtualServerConfig struct {
 	VirtualServer F5VirtualServer `json:"virtualServer,omitempty"`
 }
+
+// A version of the k8s-bigip-ctlr virtual server schema and the optional fields it knows about.
+type F5Schema struct {
+	Version string
+
+	IRules          bool
+	Policies        bool
+	ConnectionLimit bool
+}
+
+// Schema versions we can render.
+var F5Schemas = map[string]F5Schema{
+	"0.1.3": {Version: "0.1.3"},
+	"0.1.7": {Version: "0.1.7", IRules: true, Policies: true, ConnectionLimit: true},
+}
+
+const F5DefaultSchemaVersion = "0.1.3"
+
+func (s F5Schema) URL() string {
+	return fmt.Sprintf("f5schemadb://bigip-virtual-server_v%s.json", s.Version)
+}
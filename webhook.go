@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	bigipv1 "github.com/Nexinto/k8s-bigip-ipam/pkg/apis/bigip.nexinto.com/v1"
+)
+
+// Complete path of a BIG-IP SSL profile, for example Common/mysite. The same pattern is
+// used for the sslProfiles of a VirtualServerPolicy in deploy/crd.yaml.
+const sslProfilePattern = `^/?[A-Za-z0-9_.-]+(/[A-Za-z0-9_.-]+)+$`
+
+var sslProfileRegexp = regexp.MustCompile(sslProfilePattern)
+
+// The annotations that configure the loadbalancing of a Service.
+var serviceAnnotations = []string{
+	AnnNxReqVIP,
+	AnnNxVipMode,
+	AnnNxSSLProfiles,
+	AnnNxBalance,
+	AnnNxPortMap,
+	AnnNxHealthPath,
+	AnnNxHealthProtocol,
+	AnnNxHealthSend,
+	AnnNxHealthInterval,
+	AnnNxHealthTimeout,
+	AnnNxIRules,
+	AnnNxPolicies,
+	AnnNxConnectionLimit,
+	AnnNxPartition,
+	AnnNxVSPolicy,
+	AnnNxReqVIPFamilies,
+}
+
+// Serve the validating admission webhook with the certificate and key (tls.crt, tls.key)
+// from certDir, usually a mounted Secret.
+func (c *Controller) ServeWebhook(address, certDir string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/validate", c.validate)
+
+	loader := &certificateLoader{
+		certFile: filepath.Join(certDir, "tls.crt"),
+		keyFile:  filepath.Join(certDir, "tls.key"),
+	}
+	if _, err := loader.GetCertificate(nil); err != nil {
+		return err
+	}
+
+	log.Infof("serving validating webhook on %s", address)
+
+	server := &http.Server{
+		Addr:      address,
+		Handler:   mux,
+		TLSConfig: &tls.Config{GetCertificate: loader.GetCertificate},
+	}
+	return server.ListenAndServeTLS("", "")
+}
+
+// Loads the certificate of the webhook, and loads it again when the files have changed,
+// for example when cert-manager renewed the certificate in the mounted Secret.
+type certificateLoader struct {
+	sync.Mutex
+
+	certFile string
+	keyFile  string
+
+	certificate *tls.Certificate
+	modTime     time.Time
+}
+
+func (l *certificateLoader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	var modTime time.Time
+	for _, file := range []string{l.certFile, l.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return l.keep(err)
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+
+	if l.certificate != nil && modTime.Equal(l.modTime) {
+		return l.certificate, nil
+	}
+
+	// while the Secret is updated, the certificate and the key may not match yet
+	certificate, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		return l.keep(err)
+	}
+
+	log.Infof("loaded webhook certificate from %s", l.certFile)
+	l.certificate = &certificate
+	l.modTime = modTime
+	return l.certificate, nil
+}
+
+// Keep serving the last certificate if a new one cannot be loaded.
+func (l *certificateLoader) keep(err error) (*tls.Certificate, error) {
+	if l.certificate == nil {
+		return nil, fmt.Errorf("cannot load webhook certificate: %s", err.Error())
+	}
+	log.Warnf("cannot reload webhook certificate, using the previous one: %s", err.Error())
+	return l.certificate, nil
+}
+
+func (c *Controller) validate(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	review := &admissionv1beta1.AdmissionReview{}
+	if err := json.Unmarshal(body, review); err != nil || review.Request == nil {
+		http.Error(w, "expected an AdmissionReview request", http.StatusBadRequest)
+		return
+	}
+
+	response := c.admit(review.Request)
+	response.UID = review.Request.UID
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&admissionv1beta1.AdmissionReview{
+		TypeMeta: review.TypeMeta,
+		Response: response,
+	})
+}
+
+func (c *Controller) admit(request *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	var err error
+
+	switch request.Kind.Kind {
+	case "Service":
+		service := &corev1.Service{}
+		if err = json.Unmarshal(request.Object.Raw, service); err == nil {
+			service.Namespace = request.Namespace
+			if c.loadbalancingChanged(request, service) {
+				err = c.validateService(service)
+			}
+		}
+	case "VirtualServerPolicy":
+		policy := &bigipv1.VirtualServerPolicy{}
+		if err = json.Unmarshal(request.Object.Raw, policy); err == nil {
+			policy.Namespace = request.Namespace
+			err = c.validatePolicy(policy)
+		}
+	}
+
+	if err != nil {
+		log.Infof("rejecting %s '%s-%s': %s", request.Kind.Kind, request.Namespace, request.Name, err.Error())
+		return &admissionv1beta1.AdmissionResponse{
+			Allowed: false,
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Reason:  metav1.StatusReasonInvalid,
+				Code:    http.StatusUnprocessableEntity,
+				Message: err.Error(),
+			},
+		}
+	}
+
+	return &admissionv1beta1.AdmissionResponse{Allowed: true}
+}
+
+// Updates are only validated if they change the loadbalancing of the Service, so a Service
+// that is already invalid can still be changed, for example by the controller itself.
+func (c *Controller) loadbalancingChanged(request *admissionv1beta1.AdmissionRequest, service *corev1.Service) bool {
+	if request.Operation != admissionv1beta1.Update {
+		return true
+	}
+
+	old := &corev1.Service{}
+	if err := json.Unmarshal(request.OldObject.Raw, old); err != nil {
+		return true
+	}
+
+	if c.wantsVIP(old) != c.wantsVIP(service) {
+		return true
+	}
+	for _, ann := range serviceAnnotations {
+		oldValue, oldOk := old.Annotations[ann]
+		value, ok := service.Annotations[ann]
+		if ok != oldOk || value != oldValue {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate the loadbalancing annotations of a Service with the same checks the controller
+// uses. Defaults from policies and Namespaces are checked when the Service is processed.
+func (c *Controller) validateService(service *corev1.Service) error {
+	if !c.wantsVIP(service) {
+		return nil
+	}
+	_, err := c.settingsFor(service, nil)
+	return err
+}
+
+func (c *Controller) validatePolicy(policy *bigipv1.VirtualServerPolicy) error {
+	annotations := policyAnnotations(policy)
+	_, err := c.settingsFor(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: policy.Namespace, Annotations: annotations}}, nil)
+	return err
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	bigipv1 "github.com/Nexinto/k8s-bigip-ipam/pkg/apis/bigip.nexinto.com/v1"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func admissionRequest(t *testing.T, kind string, obj metav1.Object) *admissionv1beta1.AdmissionRequest {
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &admissionv1beta1.AdmissionRequest{
+		UID:       "1234",
+		Kind:      metav1.GroupVersionKind{Kind: kind},
+		Namespace: obj.GetNamespace(),
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
+
+// Test that the webhook rejects invalid annotations with a message
+func TestWebhookService(t *testing.T) {
+	c := testEnvironment()
+	c.AllowedPartitions = map[string]bool{"team-a": true, "team-b": true}
+	a := assert.New(t)
+
+	ns, err := c.Kubernetes.CoreV1().Namespaces().Get("default", metav1.GetOptions{})
+	if !a.Nil(err) {
+		return
+	}
+	ns.Annotations = map[string]string{AnnNxAllowedPartitions: "team-a"}
+	if _, err := c.Kubernetes.CoreV1().Namespaces().Update(ns); !a.Nil(err) {
+		return
+	}
+	time.Sleep(time.Second)
+
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "mysvc",
+			Namespace:   "default",
+			Annotations: map[string]string{},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80, NodePort: 32000}},
+		},
+	}
+
+	for _, tc := range []struct {
+		annotations map[string]string
+		allowed     bool
+		message     string
+	}{
+		{map[string]string{}, true, ""},
+		{map[string]string{AnnNxVipMode: "http", AnnNxSSLProfiles: "Common/mysite,/Common/other"}, true, ""},
+		{map[string]string{AnnNxPartition: "team-a"}, true, ""},
+		{map[string]string{AnnNxVipMode: "", AnnNxSSLProfiles: ""}, true, ""},
+		{map[string]string{AnnNxVipMode: "htpp"}, false, "unknown mode 'htpp'"},
+		{map[string]string{AnnNxSSLProfiles: "Common/mysite,"}, false, "malformed SSL profile ''"},
+		{map[string]string{AnnNxSSLProfiles: "mysite"}, false, "malformed SSL profile 'mysite'"},
+		{map[string]string{AnnNxVipMode: "http", AnnNxPortMap: "80:70000"}, false, "70000"},
+		{map[string]string{AnnNxPartition: "team-b"}, false, "partition 'team-b' in annotation nexinto.com/vip-partition is not allowed in namespace 'default'"},
+		{map[string]string{AnnNxPartition: "team-c"}, false, "team-c"},
+	} {
+		s.Annotations = tc.annotations
+		response := c.admit(admissionRequest(t, "Service", s))
+		a.Equal(tc.allowed, response.Allowed, "%v", tc.annotations)
+		if !tc.allowed && a.NotNil(response.Result) {
+			a.Contains(response.Result.Message, tc.message)
+		}
+	}
+}
+
+// Test that updates of an invalid Service are only rejected if they change its loadbalancing
+func TestWebhookServiceUpdate(t *testing.T) {
+	c := testEnvironment()
+	a := assert.New(t)
+
+	old := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "mysvc",
+			Namespace:   "default",
+			Annotations: map[string]string{AnnNxVipMode: "htpp"},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80, NodePort: 32000}},
+		},
+	}
+
+	update := func(s *corev1.Service) *admissionv1beta1.AdmissionRequest {
+		request := admissionRequest(t, "Service", s)
+		request.Operation = admissionv1beta1.Update
+		raw, err := json.Marshal(old)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.OldObject = runtime.RawExtension{Raw: raw}
+		return request
+	}
+
+	s := old.DeepCopy()
+	s.Annotations[AnnNxVIPState] = VIPStateInvalid
+	a.True(c.admit(update(s)).Allowed)
+
+	s = old.DeepCopy()
+	s.Annotations[AnnNxVipMode] = "tpc"
+	a.False(c.admit(update(s)).Allowed)
+
+	s = old.DeepCopy()
+	s.Annotations[AnnNxVipMode] = "http"
+	a.True(c.admit(update(s)).Allowed)
+}
+
+// Test that the webhook and the CRD accept the same SSL profiles
+func TestSSLProfilePattern(t *testing.T) {
+	a := assert.New(t)
+
+	crd, err := ioutil.ReadFile("deploy/crd.yaml")
+	if !a.Nil(err) {
+		return
+	}
+	a.Contains(string(crd), "pattern: '"+sslProfilePattern+"'")
+}
+
+// Test that a renewed certificate is picked up
+func TestCertificateReload(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "webhook-certs")
+	if !a.Nil(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	loader := &certificateLoader{
+		certFile: filepath.Join(dir, "tls.crt"),
+		keyFile:  filepath.Join(dir, "tls.key"),
+	}
+
+	_, err = loader.GetCertificate(nil)
+	a.NotNil(err)
+
+	writeCertificate(t, dir, "first", time.Now().Add(-time.Minute))
+	first, err := loader.GetCertificate(nil)
+	if !a.Nil(err) {
+		return
+	}
+
+	cached, err := loader.GetCertificate(nil)
+	if !a.Nil(err) {
+		return
+	}
+	a.True(first == cached)
+
+	writeCertificate(t, dir, "second", time.Now())
+	second, err := loader.GetCertificate(nil)
+	if !a.Nil(err) {
+		return
+	}
+	a.NotEqual(first.Certificate[0], second.Certificate[0])
+
+	// a broken certificate is not used
+	if !a.Nil(ioutil.WriteFile(loader.certFile, []byte("broken"), 0600)) {
+		return
+	}
+	kept, err := loader.GetCertificate(nil)
+	a.Nil(err)
+	a.True(second == kept)
+}
+
+// Write a self-signed certificate and its key to tls.crt and tls.key in dir.
+func writeCertificate(t *testing.T, dir, name string, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for file, block := range map[string]*pem.Block{
+		"tls.crt": {Type: "CERTIFICATE", Bytes: der},
+		"tls.key": {Type: "EC PRIVATE KEY", Bytes: keyDer},
+	} {
+		path := filepath.Join(dir, file)
+		if err := ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWebhookPolicy(t *testing.T) {
+	c := testEnvironment()
+	a := assert.New(t)
+
+	p := &bigipv1.VirtualServerPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "mypolicy", Namespace: "default"},
+		Spec:       bigipv1.VirtualServerPolicySpec{Mode: "http", SSLProfiles: []string{"Common/mysite"}},
+	}
+
+	a.True(c.admit(admissionRequest(t, "VirtualServerPolicy", p)).Allowed)
+
+	p.Spec.Partition = "team-b"
+	a.False(c.admit(admissionRequest(t, "VirtualServerPolicy", p)).Allowed)
+}
+
+// Test the AdmissionReview round trip over HTTP
+func TestWebhookHandler(t *testing.T) {
+	c := testEnvironment()
+	a := assert.New(t)
+
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "mysvc",
+			Namespace:   "default",
+			Annotations: map[string]string{AnnNxVipMode: "udp"},
+		},
+		Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeNodePort},
+	}
+
+	body, err := json.Marshal(&admissionv1beta1.AdmissionReview{Request: admissionRequest(t, "Service", s)})
+	if !a.Nil(err) {
+		return
+	}
+
+	rec := httptest.NewRecorder()
+	c.validate(rec, httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body)))
+	if !a.Equal(http.StatusOK, rec.Code) {
+		return
+	}
+
+	review := &admissionv1beta1.AdmissionReview{}
+	if !a.Nil(json.Unmarshal(rec.Body.Bytes(), review)) || !a.NotNil(review.Response) {
+		return
+	}
+	a.Equal("1234", string(review.Response.UID))
+	a.False(review.Response.Allowed)
+	a.Contains(review.Response.Result.Message, "unknown mode 'udp'")
+
+	rec = httptest.NewRecorder()
+	c.validate(rec, httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader([]byte("{}"))))
+	a.Equal(http.StatusBadRequest, rec.Code)
+}
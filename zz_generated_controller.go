@@ -17,15 +17,18 @@ import (
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 
-	corev1 "k8s.io/api/core/v1"
-
 	corelisterv1 "k8s.io/client-go/listers/core/v1"
+	extensionslisterv1beta1 "k8s.io/client-go/listers/extensions/v1beta1"
 
 	ipamclientset "github.com/Nexinto/k8s-ipam/pkg/client/clientset/versioned"
 
-	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
 	ipaminformers "github.com/Nexinto/k8s-ipam/pkg/client/informers/externalversions"
 	ipamlisterv1 "github.com/Nexinto/k8s-ipam/pkg/client/listers/ipam.nexinto.com/v1"
+
+	bigipclientset "github.com/Nexinto/k8s-bigip-ipam/pkg/client/clientset/versioned"
+
+	bigipinformers "github.com/Nexinto/k8s-bigip-ipam/pkg/client/informers/externalversions"
+	bigiplisterv1 "github.com/Nexinto/k8s-bigip-ipam/pkg/client/listers/bigip.nexinto.com/v1"
 )
 
 type Controller struct {
@@ -36,9 +39,13 @@ type Controller struct {
 	ServiceLister corelisterv1.ServiceLister
 	ServiceSynced cache.InformerSynced
 
-	ConfigMapQueue  workqueue.RateLimitingInterface
-	ConfigMapLister corelisterv1.ConfigMapLister
-	ConfigMapSynced cache.InformerSynced
+	NamespaceQueue  workqueue.RateLimitingInterface
+	NamespaceLister corelisterv1.NamespaceLister
+	NamespaceSynced cache.InformerSynced
+
+	IngressQueue  workqueue.RateLimitingInterface
+	IngressLister extensionslisterv1beta1.IngressLister
+	IngressSynced cache.InformerSynced
 
 	IpamClient  ipamclientset.Interface
 	IpamFactory ipaminformers.SharedInformerFactory
@@ -47,9 +54,41 @@ type Controller struct {
 	IpAddressLister ipamlisterv1.IpAddressLister
 	IpAddressSynced cache.InformerSynced
 
-	Tag        string
-	RequireTag bool
-	Partition  string
+	BigipClient  bigipclientset.Interface
+	BigipFactory bigipinformers.SharedInformerFactory
+
+	VirtualServerPolicyQueue  workqueue.RateLimitingInterface
+	VirtualServerPolicyLister bigiplisterv1.VirtualServerPolicyLister
+	VirtualServerPolicySynced cache.InformerSynced
+
+	Tag          string
+	RequireTag   bool
+	Partition    string
+	Balance      string
+	Schema       F5Schema
+	Routes       *RouteSupport
+	BigIPTimeout time.Duration
+	VIPRetention time.Duration
+	IPv6Labels   map[string]string
+
+	DefaultIngressClass bool
+
+	AllowedPartitions map[string]bool
+
+	ConfigMapFactory kubernetesinformers.SharedInformerFactory
+	ConfigMapQueue   workqueue.RateLimitingInterface
+	ConfigMapLister  corelisterv1.ConfigMapLister
+	ConfigMapIndexer cache.Indexer
+	ConfigMapSynced  cache.InformerSynced
+
+	DeletionQueue workqueue.RateLimitingInterface
+
+	serviceStates     serviceStateTracker
+	restoring         configMapRestorer
+	namespaceDefaults namespaceDefaultsTracker
+	warnings          warningLimiter
+	health            healthState
+	pods              podWatcher
 }
 
 // Expects the clientsets to be set.
@@ -66,64 +105,17 @@ func (c *Controller) Initialize() {
 	c.ServiceLister = ServiceInformer.Lister()
 	c.ServiceSynced = ServiceInformer.Informer().HasSynced
 
-	ServiceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-
-		AddFunc: func(obj interface{}) {
-			if key, err := cache.MetaNamespaceKeyFunc(obj); err == nil {
-				ServiceQueue.Add(key)
-			}
-		},
-
-		UpdateFunc: func(old, new interface{}) {
-			if key, err := cache.MetaNamespaceKeyFunc(new); err == nil {
-				ServiceQueue.Add(key)
-			}
-		},
-
-		DeleteFunc: func(obj interface{}) {
-			o, ok := obj.(*corev1.Service)
-
-			if !ok {
-				tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
-				if !ok {
-					log.Errorf("couldn't get object from tombstone %+v", obj)
-					return
-				}
-				o, ok = tombstone.Obj.(*corev1.Service)
-				if !ok {
-					log.Errorf("tombstone contained object that is not a Service %+v", obj)
-					return
-				}
-			}
-
-			err := c.ServiceDeleted(o)
-
-			if err != nil {
-				log.Errorf("failed to process deletion: %s", err.Error())
-			}
-		},
-	})
-
-	ConfigMapInformer := c.KubernetesFactory.Core().V1().ConfigMaps()
-	ConfigMapQueue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
-	c.ConfigMapQueue = ConfigMapQueue
-	c.ConfigMapLister = ConfigMapInformer.Lister()
-	c.ConfigMapSynced = ConfigMapInformer.Informer().HasSynced
-
-	ConfigMapInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-
-		AddFunc: func(obj interface{}) {
-			if key, err := cache.MetaNamespaceKeyFunc(obj); err == nil {
-				ConfigMapQueue.Add(key)
-			}
-		},
-
-		UpdateFunc: func(old, new interface{}) {
-			if key, err := cache.MetaNamespaceKeyFunc(new); err == nil {
-				ConfigMapQueue.Add(key)
-			}
-		},
-	})
+	NamespaceInformer := c.KubernetesFactory.Core().V1().Namespaces()
+	NamespaceQueue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	c.NamespaceQueue = NamespaceQueue
+	c.NamespaceLister = NamespaceInformer.Lister()
+	c.NamespaceSynced = NamespaceInformer.Informer().HasSynced
+
+	IngressInformer := c.KubernetesFactory.Extensions().V1beta1().Ingresses()
+	IngressQueue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	c.IngressQueue = IngressQueue
+	c.IngressLister = IngressInformer.Lister()
+	c.IngressSynced = IngressInformer.Informer().HasSynced
 
 	if c.IpamClient == nil {
 		panic("c.IpamClient is nil")
@@ -136,37 +128,16 @@ func (c *Controller) Initialize() {
 	c.IpAddressLister = IpAddressInformer.Lister()
 	c.IpAddressSynced = IpAddressInformer.Informer().HasSynced
 
-	IpAddressInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-
-		UpdateFunc: func(old, new interface{}) {
-			if key, err := cache.MetaNamespaceKeyFunc(new); err == nil {
-				IpAddressQueue.Add(key)
-			}
-		},
-
-		DeleteFunc: func(obj interface{}) {
-			o, ok := obj.(*ipamv1.IpAddress)
-
-			if !ok {
-				tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
-				if !ok {
-					log.Errorf("couldn't get object from tombstone %+v", obj)
-					return
-				}
-				o, ok = tombstone.Obj.(*ipamv1.IpAddress)
-				if !ok {
-					log.Errorf("tombstone contained object that is not a IpAddress %+v", obj)
-					return
-				}
-			}
-
-			err := c.IpAddressDeleted(o)
-
-			if err != nil {
-				log.Errorf("failed to process deletion: %s", err.Error())
-			}
-		},
-	})
+	if c.BigipClient == nil {
+		panic("c.BigipClient is nil")
+	}
+	c.BigipFactory = bigipinformers.NewSharedInformerFactory(c.BigipClient, time.Second*30)
+
+	VirtualServerPolicyInformer := c.BigipFactory.Bigip().V1().VirtualServerPolicies()
+	VirtualServerPolicyQueue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	c.VirtualServerPolicyQueue = VirtualServerPolicyQueue
+	c.VirtualServerPolicyLister = VirtualServerPolicyInformer.Lister()
+	c.VirtualServerPolicySynced = VirtualServerPolicyInformer.Informer().HasSynced
 
 	return
 }
@@ -176,6 +147,7 @@ func (c *Controller) Start() {
 	defer close(stopCh)
 	go c.KubernetesFactory.Start(stopCh)
 	go c.IpamFactory.Start(stopCh)
+	go c.BigipFactory.Start(stopCh)
 
 	go c.Run(stopCh)
 
@@ -192,10 +164,12 @@ func (c *Controller) Run(stopCh <-chan struct{}) {
 	defer runtime.HandleCrash()
 
 	defer c.ServiceQueue.ShutDown()
-	defer c.ConfigMapQueue.ShutDown()
+	defer c.NamespaceQueue.ShutDown()
+	defer c.IngressQueue.ShutDown()
 	defer c.IpAddressQueue.ShutDown()
+	defer c.VirtualServerPolicyQueue.ShutDown()
 
-	if !cache.WaitForCacheSync(stopCh, c.ServiceSynced, c.ConfigMapSynced, c.IpAddressSynced) {
+	if !cache.WaitForCacheSync(stopCh, c.ServiceSynced, c.NamespaceSynced, c.IngressSynced, c.IpAddressSynced, c.VirtualServerPolicySynced) {
 		runtime.HandleError(fmt.Errorf("Timed out waiting for caches to sync"))
 		return
 	}
@@ -204,10 +178,14 @@ func (c *Controller) Run(stopCh <-chan struct{}) {
 
 	go wait.Until(c.runServiceWorker, time.Second, stopCh)
 
-	go wait.Until(c.runConfigMapWorker, time.Second, stopCh)
+	go wait.Until(c.runNamespaceWorker, time.Second, stopCh)
+
+	go wait.Until(c.runIngressWorker, time.Second, stopCh)
 
 	go wait.Until(c.runIpAddressWorker, time.Second, stopCh)
 
+	go wait.Until(c.runVirtualServerPolicyWorker, time.Second, stopCh)
+
 	log.Debugf("started workers")
 	<-stopCh
 	log.Debugf("shutting down workers")
@@ -271,33 +249,86 @@ func (c *Controller) processService(key string) error {
 
 }
 
-func (c *Controller) runConfigMapWorker() {
-	for c.processNextConfigMap() {
+func (c *Controller) runNamespaceWorker() {
+	for c.processNextNamespace() {
+	}
+}
+
+func (c *Controller) processNextNamespace() bool {
+	obj, shutdown := c.NamespaceQueue.Get()
+	if shutdown {
+		return false
+	}
+
+	err := func(obj interface{}) error {
+		defer c.NamespaceQueue.Done(obj)
+		var key string
+		var ok bool
+
+		if key, ok = obj.(string); !ok {
+			c.NamespaceQueue.Forget(obj)
+			runtime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
+			return nil
+		}
+
+		if err := c.processNamespace(key); err != nil {
+			return fmt.Errorf("error syncing '%s': %s", key, err.Error())
+		}
+
+		c.NamespaceQueue.Forget(obj)
+		return nil
+	}(obj)
+
+	if err != nil {
+		runtime.HandleError(err)
+		return true
+	}
+
+	return true
+}
+
+func (c *Controller) processNamespace(key string) error {
+
+	o, err := c.NamespaceLister.Get(key)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return fmt.Errorf("tried to get %s, but it was not found", key)
+		} else {
+			return fmt.Errorf("error getting %s from cache: %s", key, err.Error())
+		}
+	}
+
+	return c.NamespaceCreatedOrUpdated(o)
+
+}
+
+func (c *Controller) runIngressWorker() {
+	for c.processNextIngress() {
 	}
 }
 
-func (c *Controller) processNextConfigMap() bool {
-	obj, shutdown := c.ConfigMapQueue.Get()
+func (c *Controller) processNextIngress() bool {
+	obj, shutdown := c.IngressQueue.Get()
 	if shutdown {
 		return false
 	}
 
 	err := func(obj interface{}) error {
-		defer c.ConfigMapQueue.Done(obj)
+		defer c.IngressQueue.Done(obj)
 		var key string
 		var ok bool
 
 		if key, ok = obj.(string); !ok {
-			c.ConfigMapQueue.Forget(obj)
+			c.IngressQueue.Forget(obj)
 			runtime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
 			return nil
 		}
 
-		if err := c.processConfigMap(key); err != nil {
+		if err := c.processIngress(key); err != nil {
 			return fmt.Errorf("error syncing '%s': %s", key, err.Error())
 		}
 
-		c.ConfigMapQueue.Forget(obj)
+		c.IngressQueue.Forget(obj)
 		return nil
 	}(obj)
 
@@ -309,14 +340,14 @@ func (c *Controller) processNextConfigMap() bool {
 	return true
 }
 
-func (c *Controller) processConfigMap(key string) error {
+func (c *Controller) processIngress(key string) error {
 
 	namespace, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
 		return fmt.Errorf("could not parse name %s: %s", key, err.Error())
 	}
 
-	o, err := c.ConfigMapLister.ConfigMaps(namespace).Get(name)
+	o, err := c.IngressLister.Ingresses(namespace).Get(name)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			return fmt.Errorf("tried to get %s, but it was not found", key)
@@ -325,7 +356,7 @@ func (c *Controller) processConfigMap(key string) error {
 		}
 	}
 
-	return c.ConfigMapCreatedOrUpdated(o)
+	return c.IngressCreatedOrUpdated(o)
 
 }
 
@@ -386,3 +417,61 @@ func (c *Controller) processIpAddress(key string) error {
 	return c.IpAddressCreatedOrUpdated(o)
 
 }
+
+func (c *Controller) runVirtualServerPolicyWorker() {
+	for c.processNextVirtualServerPolicy() {
+	}
+}
+
+func (c *Controller) processNextVirtualServerPolicy() bool {
+	obj, shutdown := c.VirtualServerPolicyQueue.Get()
+	if shutdown {
+		return false
+	}
+
+	err := func(obj interface{}) error {
+		defer c.VirtualServerPolicyQueue.Done(obj)
+		var key string
+		var ok bool
+
+		if key, ok = obj.(string); !ok {
+			c.VirtualServerPolicyQueue.Forget(obj)
+			runtime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
+			return nil
+		}
+
+		if err := c.processVirtualServerPolicy(key); err != nil {
+			return fmt.Errorf("error syncing '%s': %s", key, err.Error())
+		}
+
+		c.VirtualServerPolicyQueue.Forget(obj)
+		return nil
+	}(obj)
+
+	if err != nil {
+		runtime.HandleError(err)
+		return true
+	}
+
+	return true
+}
+
+func (c *Controller) processVirtualServerPolicy(key string) error {
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return fmt.Errorf("could not parse name %s: %s", key, err.Error())
+	}
+
+	o, err := c.VirtualServerPolicyLister.VirtualServerPolicies(namespace).Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return fmt.Errorf("tried to get %s, but it was not found", key)
+		} else {
+			return fmt.Errorf("error getting %s from cache: %s", key, err.Error())
+		}
+	}
+
+	return c.VirtualServerPolicyCreatedOrUpdated(o)
+
+}